@@ -48,7 +48,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	db.AutoMigrate(&models.Account{}, &models.User{}, &models.Transaction{}, &models.Position{}, &models.StockSplit{})
+	db.AutoMigrate(&models.Account{}, &models.User{}, &models.Transaction{}, &models.Position{}, &models.StockSplit{}, &models.ImportJob{}, &models.ImportRowResult{}, &models.Session{}, &models.RefreshToken{}, &models.UserToken{}, &models.RecoveryCode{}, &models.APIKey{}, &models.AccountShare{}, &models.LoginAttempt{}, &models.AuditEntry{})
 	models.InitializeStockSplits(db)
 
 	router := mux.NewRouter()
@@ -63,19 +63,46 @@ func main() {
 
 	router.HandleFunc("/signup", controller.HandleSignup).Methods("POST")
 	router.HandleFunc("/login", controller.HandleLogin).Methods("POST")
+	router.HandleFunc("/login/2fa", controller.HandleTwoFactorLogin).Methods("POST")
+	router.HandleFunc("/refresh", controller.HandleRefresh).Methods("POST")
+	router.HandleFunc("/verify-email", controller.HandleVerifyEmail).Methods("POST")
+	router.HandleFunc("/forgot-password", controller.HandleForgotPassword).Methods("POST")
+	router.HandleFunc("/reset-password", controller.HandleResetPassword).Methods("POST")
 
 	protected := router.PathPrefix("/protected").Subrouter()
+	protected.HandleFunc("/logout", controller.HandleLogout).Methods("POST")
+	protected.HandleFunc("/verify-email/resend", controller.HandleResendVerification).Methods("POST")
+	protected.HandleFunc("/2fa/enroll", controller.HandleEnrollTwoFactor).Methods("POST")
+	protected.HandleFunc("/2fa/verify", controller.HandleVerifyTwoFactor).Methods("POST")
+	protected.HandleFunc("/2fa/disable", controller.HandleDisableTwoFactor).Methods("POST")
+	protected.HandleFunc("/login-attempts", controller.HandleGetFailedLogins).Methods("GET")
+	protected.HandleFunc("/audit", controller.HandleGetAudit).Methods("GET")
+	protected.HandleFunc("/api-keys", controller.HandleCreateAPIKey).Methods("POST")
+	protected.HandleFunc("/api-keys", controller.HandleGetAPIKeys).Methods("GET")
+	protected.HandleFunc("/api-keys/{id}", controller.HandleRevokeAPIKey).Methods("DELETE")
 	protected.HandleFunc("/accounts", controller.HandleCreateAccount).Methods("POST")
 	protected.HandleFunc("/accounts", controller.HandleGetAccounts).Methods("GET")
 	protected.HandleFunc("/accounts/{id}", controller.HandleGetAccount).Methods("GET")
+	protected.HandleFunc("/accounts/{id}", controller.HandleUpdateAccount).Methods("PATCH")
 	protected.HandleFunc("/accounts/{id}", controller.HandleDeleteAccount).Methods("DELETE") // Added delete account endpoint
+	protected.HandleFunc("/accounts/{id}/shares", controller.HandleGetAccountShares).Methods("GET")
+	protected.HandleFunc("/accounts/{id}/shares", controller.HandleShareAccount).Methods("PUT")
+	protected.HandleFunc("/accounts/{id}/shares/{user_id}", controller.HandleUnshareAccount).Methods("DELETE")
 	protected.HandleFunc("/transactions", controller.HandleCreateTransaction).Methods("POST")
 	protected.HandleFunc("/transactions", controller.HandleGetTransactions).Methods("GET")
+	protected.HandleFunc("/transactions/export", controller.HandleExportTransactions).Methods("GET")
+	protected.HandleFunc("/transactions/{id}", controller.HandleUpdateTransaction).Methods("PUT", "PATCH")
 	protected.HandleFunc("/transactions/{id}", controller.HandleDeleteTransaction).Methods("DELETE")
 	protected.HandleFunc("/transactions/import", controller.HandleImport).Methods("POST") // Add this line for the import endpoint
+	protected.HandleFunc("/imports/{id}", controller.HandleGetImport).Methods("GET")
+	protected.HandleFunc("/imports/{id}", controller.HandleDeleteImport).Methods("DELETE")
 	protected.HandleFunc("/positions", controller.HandleGetPositions).Methods("GET")
+	protected.HandleFunc("/positions/consolidated", controller.HandleGetConsolidatedPositions).Methods("GET")
 	protected.HandleFunc("/quote", controller.HandleGetCurrentPrice).Methods("GET")
 	protected.HandleFunc("/quotes", controller.HandleHistoricalPrices).Methods("GET")
+	protected.HandleFunc("/reports/options-income", controller.HandleGetOptionsIncome).Methods("GET")
+	protected.HandleFunc("/backup", controller.HandleGetBackup).Methods("GET")
+	protected.HandleFunc("/backup/restore", controller.HandleRestoreBackup).Methods("POST")
 
 	protected.Use(controller.VerifyJWT)
 
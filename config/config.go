@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -13,7 +14,9 @@ type Config struct {
 		Port string `yaml:"port"`
 	} `yaml:"server"`
 	JWT struct {
-		Secret string `yaml:"secret"`
+		Secret             string `yaml:"secret"`
+		AccessTokenMinutes int    `yaml:"access_token_minutes"`
+		RefreshTokenDays   int    `yaml:"refresh_token_days"`
 	} `yaml:"jwt"`
 	MySQL struct {
 		Server   string `yaml:"server"`
@@ -22,8 +25,29 @@ type Config struct {
 		Password string `yaml:"password"`
 		Schema   string `yaml:"schema"`
 	} `yaml:"mysql"`
+	Mail struct {
+		Host     string `yaml:"host"`
+		Port     string `yaml:"port"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		From     string `yaml:"from"`
+		// AppURL is where the links in mail point to, the web app handles /verify-email and /reset-password
+		AppURL string `yaml:"app_url"`
+	} `yaml:"mail"`
+	Login struct {
+		// MaxAttempts is how many failed logins an email gets before each attempt is delayed
+		MaxAttempts int `yaml:"max_attempts"`
+		// MaxAttemptsPerIP is the same for all emails tried from one address
+		MaxAttemptsPerIP int `yaml:"max_attempts_per_ip"`
+		// LockoutMinutes is the longest delay, failures older than this are forgotten
+		LockoutMinutes int `yaml:"lockout_minutes"`
+		// ClientIPHeader names a header like X-Real-IP set by a trusted proxy, the connection
+		// address is used when empty
+		ClientIPHeader string `yaml:"client_ip_header"`
+	} `yaml:"login"`
 	Import struct {
 		DownloadPath string `yaml:"path"`
+		MaxUploadMB  int64  `yaml:"max_upload_mb"`
 	} `yaml:"import"`
 }
 
@@ -67,3 +91,60 @@ func (c Config) MySQLDNS() string {
 	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
 		c.MySQL.User, c.MySQL.Password, c.MySQL.Server, c.MySQL.Port, c.MySQL.Schema)
 }
+
+// ImportPath is the directory uploads are stored in until they are imported
+func (c Config) ImportPath() string {
+	if c.Import.DownloadPath == "" {
+		return "./uploads"
+	}
+	return c.Import.DownloadPath
+}
+
+// MaxUploadSize is the largest import file accepted in bytes, 64MB unless configured
+func (c Config) MaxUploadSize() int64 {
+	if c.Import.MaxUploadMB <= 0 {
+		return 64 << 20
+	}
+	return c.Import.MaxUploadMB << 20
+}
+
+// AccessTokenTTL is how long an access token is valid, 15 minutes unless configured
+func (c Config) AccessTokenTTL() time.Duration {
+	if c.JWT.AccessTokenMinutes <= 0 {
+		return 15 * time.Minute
+	}
+	return time.Duration(c.JWT.AccessTokenMinutes) * time.Minute
+}
+
+// RefreshTokenTTL is how long a login session can be refreshed, 30 days unless configured
+func (c Config) RefreshTokenTTL() time.Duration {
+	if c.JWT.RefreshTokenDays <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(c.JWT.RefreshTokenDays) * 24 * time.Hour
+}
+
+// LoginMaxAttempts is how many failed logins an email gets before they are delayed, 5 unless configured
+func (c Config) LoginMaxAttempts() int {
+	if c.Login.MaxAttempts <= 0 {
+		return 5
+	}
+	return c.Login.MaxAttempts
+}
+
+// LoginMaxAttemptsPerIP is how many failed logins an IP address gets before they are delayed,
+// 20 unless configured
+func (c Config) LoginMaxAttemptsPerIP() int {
+	if c.Login.MaxAttemptsPerIP <= 0 {
+		return 20
+	}
+	return c.Login.MaxAttemptsPerIP
+}
+
+// LoginLockout is the longest delay after failed logins, 15 minutes unless configured
+func (c Config) LoginLockout() time.Duration {
+	if c.Login.LockoutMinutes <= 0 {
+		return 15 * time.Minute
+	}
+	return time.Duration(c.Login.LockoutMinutes) * time.Minute
+}
@@ -12,7 +12,18 @@ import (
 
 // CreateAccountRequest is used to create a new account
 type CreateAccountRequest struct {
-	Name string `json:"name" binding:"required"`
+	Name            string `json:"name" binding:"required"`
+	Broker          string `json:"broker"`
+	Type            string `json:"type"`
+	CostBasisMethod string `json:"cost_basis_method"`
+}
+
+// UpdateAccountRequest holds the account settings to change, fields left out keep their value
+type UpdateAccountRequest struct {
+	Name            *string `json:"name"`
+	Broker          *string `json:"broker"`
+	Type            *string `json:"type"`
+	CostBasisMethod *string `json:"cost_basis_method"`
 }
 
 // HandleCreateAccount handles the creation of a new account
@@ -29,11 +40,21 @@ func (c *Controller) HandleCreateAccount(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	account := &models.Account{UserID: u.ID, Name: req.Name}
+	if req.Type != "" && !models.ValidAccountType(req.Type) {
+		http.Error(w, "Invalid account type", http.StatusBadRequest)
+		return
+	}
+	if req.CostBasisMethod != "" && !models.ValidCostBasisMethod(req.CostBasisMethod) {
+		http.Error(w, "Invalid cost basis method", http.StatusBadRequest)
+		return
+	}
+
+	account := &models.Account{UserID: u.ID, Name: req.Name, Broker: req.Broker, Type: req.Type, CostBasisMethod: req.CostBasisMethod}
 	if err := c.db.Create(account).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	c.audit(r, u, models.AuditAccountCreate, account.ID, account.ID, nil, models.AccountAuditValues(account))
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(account)
@@ -48,20 +69,8 @@ func (c *Controller) HandleGetAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u, err := userFromRequestContext(r, c.db)
-	if err != nil {
-		http.Error(w, "Unable to find user", http.StatusUnauthorized)
-		return
-	}
-
-	account, err := models.FindAccountByID(c.db, uint(accountID))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if account.UserID != u.ID {
-		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+	_, account, ok := c.authorizeAccount(w, r, uint(accountID), models.RoleViewer)
+	if !ok {
 		return
 	}
 
@@ -69,7 +78,7 @@ func (c *Controller) HandleGetAccount(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(account)
 }
 
-// HandleGetAccounts handles fetching accounts for a specific user
+// HandleGetAccounts handles fetching the accounts a user owns or that are shared with them
 func (c *Controller) HandleGetAccounts(w http.ResponseWriter, r *http.Request) {
 	u, err := userFromRequestContext(r, c.db)
 	if err != nil {
@@ -77,7 +86,7 @@ func (c *Controller) HandleGetAccounts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accounts, err := models.FetchAccountsByUserID(c.db, u.ID)
+	accounts, err := models.FetchUserAccounts(c.db, u.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -87,8 +96,8 @@ func (c *Controller) HandleGetAccounts(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(accounts)
 }
 
-// HandleDeleteAccount handles deleting an account and its related transactions and positions
-func (c *Controller) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+// HandleUpdateAccount handles changing the name and settings of an account
+func (c *Controller) HandleUpdateAccount(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	accountID, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -96,22 +105,67 @@ func (c *Controller) HandleDeleteAccount(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	u, err := userFromRequestContext(r, c.db)
-	if err != nil {
-		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+	var req UpdateAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	account, err := models.FindAccountByID(c.db, uint(accountID))
+	u, account, ok := c.authorizeAccount(w, r, uint(accountID), models.RoleOwner)
+	if !ok {
+		return
+	}
+	before := models.AccountAuditValues(account)
+
+	if req.Name != nil {
+		if *req.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		account.Name = *req.Name
+	}
+	if req.Broker != nil {
+		account.Broker = *req.Broker
+	}
+	if req.Type != nil {
+		if !models.ValidAccountType(*req.Type) {
+			http.Error(w, "Invalid account type", http.StatusBadRequest)
+			return
+		}
+		account.Type = *req.Type
+	}
+	if req.CostBasisMethod != nil {
+		if !models.ValidCostBasisMethod(*req.CostBasisMethod) {
+			http.Error(w, "Invalid cost basis method", http.StatusBadRequest)
+			return
+		}
+		account.CostBasisMethod = *req.CostBasisMethod
+	}
+
+	if err := models.UpdateAccount(c.db, account); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.audit(r, u, models.AuditAccountUpdate, account.ID, account.ID, before, models.AccountAuditValues(account))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(account)
+}
+
+// HandleDeleteAccount handles deleting an account and its related transactions and positions
+func (c *Controller) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Account not found", http.StatusNotFound)
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
 		return
 	}
 
-	if account.UserID != u.ID {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	u, account, ok := c.authorizeAccount(w, r, uint(accountID), models.RoleOwner)
+	if !ok {
 		return
 	}
+	before := models.AccountAuditValues(account)
 
 	// Check if there are any transactions for the account
 	var transactionCount int64
@@ -143,11 +197,19 @@ func (c *Controller) HandleDeleteAccount(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	// Other users lose their access with the account
+	if err := c.db.Where("account_id = ?", account.ID).Delete(&models.AccountShare{}).Error; err != nil {
+		http.Error(w, "Failed to delete account shares", http.StatusInternalServerError)
+		return
+	}
+
 	// Delete the account
 	if err := c.db.Delete(account).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	before["transactions"] = transactionCount
+	c.audit(r, u, models.AuditAccountDelete, account.ID, account.ID, before, nil)
 
 	w.WriteHeader(http.StatusNoContent)
 }
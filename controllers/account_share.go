@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+
+	"stock-portfolio-api/models"
+)
+
+// ShareAccountRequest gives the user with the email viewer or editor access
+type ShareAccountRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// AccountShareResponse is a user the account is shared with
+type AccountShareResponse struct {
+	UserID    uint      `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newAccountShareResponse(share models.AccountShare) AccountShareResponse {
+	return AccountShareResponse{
+		UserID:    share.UserID,
+		Email:     share.User.Email,
+		Role:      share.Role,
+		CreatedAt: share.CreatedAt,
+	}
+}
+
+// HandleGetAccountShares handles listing who an account is shared with, only the owner can see it
+func (c *Controller) HandleGetAccountShares(w http.ResponseWriter, r *http.Request) {
+	accountID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	_, account, ok := c.authorizeAccount(w, r, uint(accountID), models.RoleOwner)
+	if !ok {
+		return
+	}
+
+	shares, err := models.FetchAccountShares(c.db, account.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := make([]AccountShareResponse, 0, len(shares))
+	for _, share := range shares {
+		response = append(response, newAccountShareResponse(share))
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleShareAccount handles sharing an account with another user or changing their role. An
+// email without a user is ignored.
+func (c *Controller) HandleShareAccount(w http.ResponseWriter, r *http.Request) {
+	accountID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ShareAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+	if !models.ValidShareRole(req.Role) {
+		http.Error(w, "role must be viewer or editor", http.StatusBadRequest)
+		return
+	}
+
+	u, account, ok := c.authorizeAccount(w, r, uint(accountID), models.RoleOwner)
+	if !ok {
+		return
+	}
+
+	// The response is the same whether or not the email belongs to a user so sharing can't be
+	// used to find registered addresses
+	grantee, err := models.FindUserByEmail(c.db, req.Email)
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if grantee.ID == u.ID {
+		http.Error(w, "The owner can't share an account with themselves", http.StatusBadRequest)
+		return
+	}
+
+	var before interface{}
+	if role, err := models.AccountRole(c.db, grantee.ID, account.ID); err == nil {
+		before = map[string]interface{}{"user_id": grantee.ID, "email": grantee.Email, "role": role}
+	}
+	share, err := models.ShareAccount(c.db, account.ID, grantee.ID, req.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.audit(r, u, models.AuditAccountShare, account.ID, share.ID, before, map[string]interface{}{"user_id": grantee.ID, "email": grantee.Email, "role": share.Role})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleUnshareAccount handles removing a user's access to an account. The owner can remove
+// anyone and a user can remove their own access.
+func (c *Controller) HandleUnshareAccount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	u, account, ok := c.authorizeAccount(w, r, uint(accountID), models.RoleViewer)
+	if !ok {
+		return
+	}
+	if account.Role != models.RoleOwner && uint(userID) != u.ID {
+		http.Error(w, "Insufficient access to account", http.StatusForbidden)
+		return
+	}
+
+	role, _ := models.AccountRole(c.db, uint(userID), account.ID)
+	err = models.UnshareAccount(c.db, account.ID, uint(userID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Account is not shared with the user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.audit(r, u, models.AuditAccountUnshare, account.ID, uint(userID), map[string]interface{}{"user_id": userID, "role": role}, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,160 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"stock-portfolio-api/config"
+	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+
+	"github.com/gorilla/mux"
+)
+
+// TestAccountSharing checks viewers can only read a shared account and editors can also change
+// its transactions, while the account itself stays with the owner
+func TestAccountSharing(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cfg.Import.DownloadPath = t.TempDir()
+	cont := controllers.InitController(db, cfg)
+
+	owner := models.User{Email: "owner@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&owner)
+	viewer := models.User{Email: "viewer@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&viewer)
+	editor := models.User{Email: "editor@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&editor)
+	account := models.Account{UserID: owner.ID, Name: "Joint"}
+	db.Create(&account)
+	transaction := models.Transaction{AccountID: account.ID, Date: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), Action: "Buy", Symbol: "AAPL", Quantity: 10, Price: 100, Amount: -1000}
+	db.Create(&transaction)
+
+	accountID := fmt.Sprint(account.ID)
+	withVars := func(r *http.Request, vars map[string]string) *http.Request {
+		return mux.SetURLVars(r, vars)
+	}
+	share := func(userID uint, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/protected/accounts/"+accountID+"/shares", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		cont.HandleShareAccount(rr, withUser(withVars(req, map[string]string{"id": accountID}), userID))
+		return rr
+	}
+
+	if rr := share(owner.ID, `{"email": "viewer@example.com", "role": "owner"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("share as owner: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	// An unknown email gets the same response as a user's, without creating a share
+	unknown := share(owner.ID, `{"email": "nobody@example.com", "role": "viewer"}`)
+	if rr := share(owner.ID, `{"email": "viewer@example.com", "role": "editor"}`); rr.Code != http.StatusNoContent {
+		t.Fatalf("share: got status %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if unknown.Code != http.StatusNoContent || unknown.Body.Len() != 0 {
+		t.Errorf("share with an unknown email: got status %v body %q want %v and no body", unknown.Code, unknown.Body.String(), http.StatusNoContent)
+	}
+	// Sharing again changes the role
+	if rr := share(owner.ID, `{"email": "viewer@example.com", "role": "viewer"}`); rr.Code != http.StatusNoContent {
+		t.Fatalf("change role: got status %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := share(owner.ID, `{"email": "editor@example.com", "role": "editor"}`); rr.Code != http.StatusNoContent {
+		t.Fatalf("share: got status %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := share(editor.ID, `{"email": "editor@example.com", "role": "editor"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("editor sharing: got status %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	get := func(target string) *http.Request {
+		return httptest.NewRequest("GET", target, nil)
+	}
+	transactionID := fmt.Sprint(transaction.ID)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		request func() *http.Request
+		viewer  int
+		editor  int
+	}{
+		{"get account", cont.HandleGetAccount, func() *http.Request {
+			return withVars(get("/protected/accounts/"+accountID), map[string]string{"id": accountID})
+		}, http.StatusOK, http.StatusOK},
+		{"transactions", cont.HandleGetTransactions, func() *http.Request {
+			return get("/protected/transactions?account_id=" + accountID)
+		}, http.StatusOK, http.StatusOK},
+		{"update transaction", cont.HandleUpdateTransaction, func() *http.Request {
+			req := httptest.NewRequest("PATCH", "/protected/transactions/"+transactionID, strings.NewReader(`{"Quantity": "12"}`))
+			return withVars(req, map[string]string{"id": transactionID})
+		}, http.StatusForbidden, http.StatusOK},
+		{"create transaction", cont.HandleCreateTransaction, func() *http.Request {
+			return httptest.NewRequest("POST", "/protected/transactions", strings.NewReader(`{"Date": "2024-01-06", "Action": "Buy", "Symbol": "MSFT", "Quantity": "1", "Price": "1", "FeesComm": "0", "Amount": "-1", "AccountID": `+accountID+`}`))
+		}, http.StatusForbidden, http.StatusCreated},
+		{"update account", cont.HandleUpdateAccount, func() *http.Request {
+			req := httptest.NewRequest("PATCH", "/protected/accounts/"+accountID, strings.NewReader(`{"name": "Mine"}`))
+			return withVars(req, map[string]string{"id": accountID})
+		}, http.StatusForbidden, http.StatusForbidden},
+		{"delete account", cont.HandleDeleteAccount, func() *http.Request {
+			return withVars(httptest.NewRequest("DELETE", "/protected/accounts/"+accountID, nil), map[string]string{"id": accountID})
+		}, http.StatusForbidden, http.StatusForbidden},
+		{"list shares", cont.HandleGetAccountShares, func() *http.Request {
+			return withVars(get("/protected/accounts/"+accountID+"/shares"), map[string]string{"id": accountID})
+		}, http.StatusForbidden, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tt.handler(rr, withUser(tt.request(), viewer.ID))
+			if rr.Code != tt.viewer {
+				t.Errorf("viewer: got status %v want %v: %s", rr.Code, tt.viewer, rr.Body.String())
+			}
+			rr = httptest.NewRecorder()
+			tt.handler(rr, withUser(tt.request(), editor.ID))
+			if rr.Code != tt.editor {
+				t.Errorf("editor: got status %v want %v: %s", rr.Code, tt.editor, rr.Body.String())
+			}
+		})
+	}
+
+	// Shared accounts are listed with the user's role
+	rr := httptest.NewRecorder()
+	cont.HandleGetAccounts(rr, withUser(get("/protected/accounts"), viewer.ID))
+	var accounts []models.Account
+	json.NewDecoder(rr.Body).Decode(&accounts)
+	if len(accounts) != 1 || accounts[0].ID != account.ID || accounts[0].Role != models.RoleViewer {
+		t.Errorf("got accounts %+v want the shared account as viewer", accounts)
+	}
+
+	rr = httptest.NewRecorder()
+	cont.HandleGetAccountShares(rr, withUser(withVars(get("/protected/accounts/"+accountID+"/shares"), map[string]string{"id": accountID}), owner.ID))
+	var shares []controllers.AccountShareResponse
+	json.NewDecoder(rr.Body).Decode(&shares)
+	if len(shares) != 2 || shares[0].Email != "viewer@example.com" || shares[0].Role != models.RoleViewer || shares[1].Role != models.RoleEditor {
+		t.Errorf("got shares %+v want the viewer and editor", shares)
+	}
+
+	unshare := func(asUser, userID uint) int {
+		vars := map[string]string{"id": accountID, "user_id": fmt.Sprint(userID)}
+		req := withVars(httptest.NewRequest("DELETE", "/protected/accounts/"+accountID+"/shares/"+vars["user_id"], nil), vars)
+		rr := httptest.NewRecorder()
+		cont.HandleUnshareAccount(rr, withUser(req, asUser))
+		return rr.Code
+	}
+	if code := unshare(viewer.ID, editor.ID); code != http.StatusForbidden {
+		t.Errorf("viewer removing the editor: got status %v want %v", code, http.StatusForbidden)
+	}
+	if code := unshare(viewer.ID, viewer.ID); code != http.StatusNoContent {
+		t.Errorf("viewer leaving: got status %v want %v", code, http.StatusNoContent)
+	}
+	if code := unshare(owner.ID, editor.ID); code != http.StatusNoContent {
+		t.Errorf("owner removing the editor: got status %v want %v", code, http.StatusNoContent)
+	}
+
+	rr = httptest.NewRecorder()
+	cont.HandleGetTransactions(rr, withUser(get("/protected/transactions?account_id="+accountID), editor.ID))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("after unsharing: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
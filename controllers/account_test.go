@@ -0,0 +1,63 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"stock-portfolio-api/config"
+	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHandleUpdateAccount(t *testing.T) {
+	db := setupDB(t)
+	cont := controllers.InitController(db, &config.Config{})
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&user)
+	account := models.Account{UserID: user.ID, Name: "Test Account"}
+	db.Create(&account)
+	other := models.User{Email: "other@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&other)
+
+	update := func(userID uint, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/protected/accounts/1", strings.NewReader(body))
+		req = mux.SetURLVars(withUser(req, userID), map[string]string{"id": "1"})
+		rr := httptest.NewRecorder()
+		cont.HandleUpdateAccount(rr, req)
+		return rr
+	}
+
+	if rr := update(other.ID, `{"name": "Stolen"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := update(user.ID, `{"type": "401k"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := update(user.ID, `{"cost_basis_method": "random"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr := update(user.ID, `{"name": "Retirement", "broker": "Fidelity", "type": "ira"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var resp models.Account
+	json.NewDecoder(rr.Body).Decode(&resp)
+
+	stored, err := models.FindAccountByID(db, account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Name != "Retirement" || stored.Broker != "Fidelity" || stored.Type != models.AccountIRA || stored.CostBasisMethod != models.CostBasisFIFO {
+		t.Errorf("got %+v want the renamed Fidelity IRA with FIFO cost basis", stored)
+	}
+	if !stored.TaxAdvantaged() || resp.Name != "Retirement" {
+		t.Errorf("got tax advantaged %v and name %q want true and Retirement", stored.TaxAdvantaged(), resp.Name)
+	}
+}
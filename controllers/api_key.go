@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"stock-portfolio-api/models"
+)
+
+// CreateAPIKeyRequest names a new API key and limits what it can do
+type CreateAPIKeyRequest struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+}
+
+// HandleCreateAPIKey handles creating an API key, the key is only returned in this response
+func (c Controller) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "name is required and at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = models.APIKeyScopeRead
+	}
+	if !models.ValidAPIKeyScope(req.Scope) {
+		http.Error(w, "scope must be read, import or full", http.StatusBadRequest)
+		return
+	}
+
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	apiKey, key, err := models.CreateAPIKey(c.db, u.ID, req.Name, req.Scope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		*models.APIKey
+		Key string `json:"key"`
+	}{apiKey, key})
+}
+
+// HandleGetAPIKeys handles listing the user's API keys without the keys themselves
+func (c Controller) HandleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := models.FetchAPIKeys(c.db, u.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(keys)
+}
+
+// HandleRevokeAPIKey handles revoking an API key, it stops working immediately
+func (c Controller) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	err = models.RevokeAPIKey(c.db, u.ID, uint(id))
+	if errors.Is(err, models.ErrInvalidAPIKey) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+
+	"stock-portfolio-api/config"
+	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+)
+
+func TestAPIKeys(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cfg.JWT.Secret = "secret"
+	cont := controllers.InitController(db, cfg)
+
+	user := &models.User{Email: "test@example.com"}
+	if _, err := models.CreateUser(db, user); err != nil {
+		t.Fatal(err)
+	}
+	session, _, err := models.CreateSession(db, user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":  user.ID,
+		"sid": session.ID,
+	}).SignedString([]byte(cfg.JWT.Secret))
+
+	router := mux.NewRouter()
+	protected := router.PathPrefix("/protected").Subrouter()
+	protected.HandleFunc("/api-keys", cont.HandleCreateAPIKey).Methods("POST")
+	protected.HandleFunc("/api-keys", cont.HandleGetAPIKeys).Methods("GET")
+	protected.HandleFunc("/api-keys/{id}", cont.HandleRevokeAPIKey).Methods("DELETE")
+	protected.HandleFunc("/accounts", cont.HandleCreateAccount).Methods("POST")
+	protected.HandleFunc("/accounts", cont.HandleGetAccounts).Methods("GET")
+	protected.HandleFunc("/transactions/import", cont.HandleImport).Methods("POST")
+	protected.Use(cont.VerifyJWT)
+
+	call := func(method, path, header, credential, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(header, credential)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	createKey := func(scope string) string {
+		rr := call("POST", "/protected/api-keys", "Authorization", "Bearer "+accessToken, `{"name": "cron", "scope": "`+scope+`"}`)
+		var body struct {
+			Key    string `json:"key"`
+			Prefix string `json:"prefix"`
+		}
+		json.NewDecoder(rr.Body).Decode(&body)
+		if rr.Code != http.StatusCreated || !strings.HasPrefix(body.Key, body.Prefix) {
+			t.Fatalf("create %s key: got status %v body %+v", scope, rr.Code, body)
+		}
+		return body.Key
+	}
+
+	if rr := call("POST", "/protected/api-keys", "Authorization", "Bearer "+accessToken, `{"name": "cron", "scope": "admin"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown scope: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	readKey := createKey(models.APIKeyScopeRead)
+	importKey := createKey(models.APIKeyScopeImport)
+	fullKey := createKey(models.APIKeyScopeFull)
+
+	// want 0 only checks the request got past authentication
+	tests := []struct {
+		name, method, path, header, credential, body string
+		want                                         int
+	}{
+		{"read key can read", "GET", "/protected/accounts", "X-API-Key", readKey, "", http.StatusOK},
+		{"read key with the ApiKey scheme", "GET", "/protected/accounts", "Authorization", "ApiKey " + readKey, "", http.StatusOK},
+		{"read key can't write", "POST", "/protected/accounts", "X-API-Key", readKey, `{"name": "IRA"}`, http.StatusForbidden},
+		{"read key can't import", "POST", "/protected/transactions/import", "X-API-Key", readKey, "", http.StatusForbidden},
+		{"import key can import", "POST", "/protected/transactions/import", "X-API-Key", importKey, "", 0},
+		{"import key can't write elsewhere", "POST", "/protected/accounts", "X-API-Key", importKey, `{"name": "IRA"}`, http.StatusForbidden},
+		{"full key can write", "POST", "/protected/accounts", "X-API-Key", fullKey, `{"name": "IRA"}`, http.StatusCreated},
+		{"full key can't manage keys", "POST", "/protected/api-keys", "X-API-Key", fullKey, `{"name": "more"}`, http.StatusForbidden},
+		{"unknown key", "GET", "/protected/accounts", "X-API-Key", "spk_unknown", "", http.StatusUnauthorized},
+		{"key as a bearer token", "GET", "/protected/accounts", "Authorization", "Bearer " + readKey, "", http.StatusOK},
+		{"bearer key keeps its scope", "POST", "/protected/accounts", "Authorization", "Bearer " + readKey, `{"name": "IRA"}`, http.StatusForbidden},
+		{"missing scheme", "GET", "/protected/accounts", "Authorization", accessToken, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := call(tt.method, tt.path, tt.header, tt.credential, tt.body)
+			if tt.want == 0 && (rr.Code == http.StatusUnauthorized || rr.Code == http.StatusForbidden) {
+				t.Errorf("got status %v want the handler to run: %s", rr.Code, rr.Body.String())
+			}
+			if tt.want != 0 && rr.Code != tt.want {
+				t.Errorf("got status %v want %v: %s", rr.Code, tt.want, rr.Body.String())
+			}
+		})
+	}
+
+	rr := call("GET", "/protected/api-keys", "Authorization", "Bearer "+accessToken, "")
+	var keys []models.APIKey
+	json.NewDecoder(rr.Body).Decode(&keys)
+	if len(keys) != 3 || keys[0].LastUsedAt == nil || keys[1].LastUsedAt == nil {
+		t.Fatalf("got keys %+v want 3 with the read and import keys used", keys)
+	}
+	if strings.Contains(rr.Body.String(), readKey) {
+		t.Error("the key list must not contain the keys")
+	}
+
+	if rr := call("DELETE", fmt.Sprintf("/protected/api-keys/%d", keys[0].ID), "Authorization", "Bearer "+accessToken, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("revoke: got status %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := call("GET", "/protected/accounts", "X-API-Key", readKey, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := call("DELETE", fmt.Sprintf("/protected/api-keys/%d", keys[0].ID), "Authorization", "Bearer "+accessToken, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("revoke twice: got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
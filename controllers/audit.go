@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"stock-portfolio-api/models"
+)
+
+// AuditEntryResponse is an audit entry with the email of the user who made the change
+type AuditEntryResponse struct {
+	models.AuditEntry
+	UserEmail string `json:"user_email"`
+}
+
+// audit records a change made by the request, a change that was made is not undone when it
+// can't be recorded. accountID is 0 for changes outside of an account.
+func (c Controller) audit(r *http.Request, u *models.User, action string, accountID, entityID uint, before, after interface{}) {
+	entry := &models.AuditEntry{
+		UserID:   u.ID,
+		IP:       c.clientIP(r),
+		Action:   action,
+		EntityID: entityID,
+	}
+	if accountID != 0 {
+		entry.AccountID = &accountID
+	}
+	if keyID, ok := r.Context().Value("api_key").(uint); ok {
+		entry.APIKeyID = &keyID
+	}
+	if err := models.RecordAudit(c.db, entry, before, after); err != nil {
+		log.Println("Record audit entry: ", err)
+	}
+}
+
+// HandleGetAudit handles listing the changes the user made and the changes to the accounts they
+// can view, newest first. The before query parameter pages with the next_before of a response.
+func (c *Controller) HandleGetAudit(w http.ResponseWriter, r *http.Request) {
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.AuditFilter{Action: query.Get("action")}
+	if accountID := query.Get("account_id"); accountID != "" {
+		id, err := strconv.Atoi(accountID)
+		if err != nil {
+			http.Error(w, "Invalid account_id", http.StatusBadRequest)
+			return
+		}
+		filter.AccountID = uint(id)
+	}
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.ParseInLocation("2006-01-02", from, time.Local); err != nil {
+			http.Error(w, "Invalid from date", http.StatusBadRequest)
+			return
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.ParseInLocation("2006-01-02", to, time.Local); err != nil {
+			http.Error(w, "Invalid to date", http.StatusBadRequest)
+			return
+		}
+		// The to date is included
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+	if before := query.Get("before"); before != "" {
+		id, err := strconv.Atoi(before)
+		if err != nil {
+			http.Error(w, "Invalid before", http.StatusBadRequest)
+			return
+		}
+		filter.Before = uint(id)
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	if limit > 500 {
+		limit = 500
+	}
+
+	entries, err := models.FetchAuditEntries(c.db, u.ID, filter, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]AuditEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		response = append(response, AuditEntryResponse{AuditEntry: entry, UserEmail: entry.User.Email})
+	}
+	var nextBefore *uint
+	if len(entries) == limit {
+		nextBefore = &entries[len(entries)-1].ID
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":     response,
+		"next_before": nextBefore,
+	})
+}
@@ -0,0 +1,114 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"stock-portfolio-api/config"
+	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+
+	"github.com/gorilla/mux"
+)
+
+type auditResponse struct {
+	Entries    []controllers.AuditEntryResponse `json:"entries"`
+	NextBefore *uint                            `json:"next_before"`
+}
+
+func TestAuditLog(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cont := controllers.InitController(db, cfg)
+
+	owner := models.User{Email: "owner@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&owner)
+	editor := models.User{Email: "editor@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&editor)
+	stranger := models.User{Email: "stranger@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&stranger)
+
+	rr := httptest.NewRecorder()
+	cont.HandleCreateAccount(rr, withUser(httptest.NewRequest("POST", "/protected/accounts", strings.NewReader(`{"name": "Joint"}`)), owner.ID))
+	var account models.Account
+	json.NewDecoder(rr.Body).Decode(&account)
+	if _, err := models.ShareAccount(db, account.ID, editor.ID, models.RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	body := fmt.Sprintf(`{"Date": "2024-01-05", "Action": "Buy", "Symbol": "AAPL", "Description": "", "Quantity": "10", "Price": "100", "FeesComm": "0", "Amount": "-1000", "AccountID": %d}`, account.ID)
+	cont.HandleCreateTransaction(rr, withUser(httptest.NewRequest("POST", "/protected/transactions", strings.NewReader(body)), owner.ID))
+	var transaction models.Transaction
+	json.NewDecoder(rr.Body).Decode(&transaction)
+
+	// The editor changes the price, the owner should be able to see who did it and what changed
+	id := fmt.Sprint(transaction.ID)
+	req := mux.SetURLVars(httptest.NewRequest("PATCH", "/protected/transactions/"+id, strings.NewReader(`{"Price": "90"}`)), map[string]string{"id": id})
+	req.RemoteAddr = "10.0.0.7:1234"
+	rr = httptest.NewRecorder()
+	cont.HandleUpdateTransaction(rr, withUser(req, editor.ID))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update: got status %v want %v", rr.Code, http.StatusOK)
+	}
+
+	getAudit := func(userID uint, query string) auditResponse {
+		rr := httptest.NewRecorder()
+		cont.HandleGetAudit(rr, withUser(httptest.NewRequest("GET", "/protected/audit"+query, nil), userID))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("audit: got status %v want %v", rr.Code, http.StatusOK)
+		}
+		var response auditResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return response
+	}
+
+	audit := getAudit(owner.ID, "")
+	actions := []string{}
+	for _, e := range audit.Entries {
+		actions = append(actions, e.Action)
+	}
+	want := []string{models.AuditTransactionUpdate, models.AuditTransactionCreate, models.AuditAccountCreate}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Fatalf("got actions %v want %v", actions, want)
+	}
+
+	update := audit.Entries[0]
+	if update.UserEmail != "editor@example.com" || update.IP != "10.0.0.7" || update.EntityID != transaction.ID || update.AccountID == nil || *update.AccountID != account.ID {
+		t.Errorf("got update entry %+v want the editor's change to the transaction", update)
+	}
+	var before, after map[string]interface{}
+	json.Unmarshal([]byte(update.Before), &before)
+	json.Unmarshal([]byte(update.After), &after)
+	if before["price"] != 100.0 || after["price"] != 90.0 || after["symbol"] != "AAPL" {
+		t.Errorf("got before %v after %v want the price to change from 100 to 90", before, after)
+	}
+
+	if audit := getAudit(stranger.ID, ""); len(audit.Entries) != 0 {
+		t.Errorf("got %d entries for another user want none", len(audit.Entries))
+	}
+
+	filtered := getAudit(owner.ID, "?action="+models.AuditAccountCreate)
+	if len(filtered.Entries) != 1 || filtered.Entries[0].Action != models.AuditAccountCreate {
+		t.Errorf("got %+v want only the account creation", filtered.Entries)
+	}
+
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	if audit := getAudit(owner.ID, "?from="+tomorrow); len(audit.Entries) != 0 {
+		t.Errorf("got %d entries from tomorrow want none", len(audit.Entries))
+	}
+
+	page := getAudit(owner.ID, "?limit=2")
+	if len(page.Entries) != 2 || page.NextBefore == nil {
+		t.Fatalf("got %d entries and next_before %v want 2 and a next page", len(page.Entries), page.NextBefore)
+	}
+	page = getAudit(owner.ID, fmt.Sprintf("?limit=2&before=%d", *page.NextBefore))
+	if len(page.Entries) != 1 || page.Entries[0].Action != models.AuditAccountCreate || page.NextBefore != nil {
+		t.Errorf("got second page %+v want the account creation", page.Entries)
+	}
+}
@@ -7,20 +7,41 @@ import (
 	"strings"
 
 	"github.com/golang-jwt/jwt"
+
+	"stock-portfolio-api/models"
 )
 
+// sessionOnlyPaths manage the login itself, API keys can't use them so a leaked key can't
+// create more keys or lock the user out
+var sessionOnlyPaths = []string{
+	"/protected/api-keys",
+	"/protected/2fa",
+	"/protected/logout",
+	"/protected/verify-email",
+}
+
+// VerifyJWT authenticates protected routes with an access token ("Authorization: Bearer <token>")
+// or an API key ("Authorization: ApiKey <key>", "Authorization: Bearer <key>" or "X-API-Key: <key>")
 func (c Controller) VerifyJWT(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			c.verifyAPIKey(w, r, key, next)
+			return
+		}
+
 		// get the token from the Authorization header
-		tokenString := r.Header.Get("Authorization")
-		if tokenString == "" {
+		scheme, tokenString, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		// Clients that only support bearer tokens can send an API key in their place
+		if strings.EqualFold(scheme, "ApiKey") && tokenString != "" ||
+			strings.EqualFold(scheme, "Bearer") && models.IsAPIKey(tokenString) {
+			c.verifyAPIKey(w, r, tokenString, next)
+			return
+		}
+		if !strings.EqualFold(scheme, "Bearer") || tokenString == "" {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		splitToken := strings.Split(tokenString, "Bearer ")
-		tokenString = splitToken[1]
-
 		// validate the token
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -35,11 +56,65 @@ func (c Controller) VerifyJWT(next http.Handler) http.Handler {
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			ctx := context.WithValue(r.Context(), "id", claims["id"])
-			next.ServeHTTP(w, r.WithContext(ctx))
-		} else {
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok || !token.Valid {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		// The token stops working as soon as its session is revoked by a logout
+		userID, idOK := claims["id"].(float64)
+		sessionID, sidOK := claims["sid"].(float64)
+		if !idOK || !sidOK {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		session, err := models.FindActiveSession(c.db, uint(sessionID))
+		if err != nil || session.UserID != uint(userID) {
+			http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+			return
 		}
+
+		ctx := context.WithValue(r.Context(), "id", claims["id"])
+		ctx = context.WithValue(ctx, "sid", claims["sid"])
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// verifyAPIKey authenticates the request with an API key and checks the key's scope allows it
+func (c Controller) verifyAPIKey(w http.ResponseWriter, r *http.Request, key string, next http.Handler) {
+	apiKey, err := models.FindActiveAPIKey(c.db, key)
+	if err != nil {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+	if !apiKeyAllows(apiKey.Scope, r) {
+		http.Error(w, "API key scope does not allow this request", http.StatusForbidden)
+		return
+	}
+
+	// "id" is a float64 like the claim of an access token, there is no session
+	ctx := context.WithValue(r.Context(), "id", float64(apiKey.UserID))
+	ctx = context.WithValue(ctx, "api_key", apiKey.ID)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// apiKeyAllows reports whether a key with the scope may make the request
+func apiKeyAllows(scope string, r *http.Request) bool {
+	for _, path := range sessionOnlyPaths {
+		if r.URL.Path == path || strings.HasPrefix(r.URL.Path, path+"/") {
+			return false
+		}
+	}
+
+	readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+	switch scope {
+	case models.APIKeyScopeRead:
+		return readOnly
+	case models.APIKeyScopeImport:
+		return readOnly || (r.Method == http.MethodPost && r.URL.Path == "/protected/transactions/import")
+	case models.APIKeyScopeFull:
+		return true
+	}
+	return false
+}
@@ -1,11 +1,15 @@
 package controllers_test
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"stock-portfolio-api/config"
 	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 )
@@ -19,12 +23,19 @@ func TestVerifyJWT(t *testing.T) {
 	cfg := &config.Config{}
 	cfg.JWT.Secret = "secret"
 
-	cont := controllers.InitController(nil, cfg)
+	db := setupDB(t)
+	cont := controllers.InitController(db, cfg)
+	session, _, err := models.CreateSession(db, 123, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	// create a test case for a valid token
 	t.Run("Valid token", func(t *testing.T) {
 		// create a request with a valid token in the Authorization header
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"id": "123",
+			"id":  123,
+			"sid": session.ID,
 		})
 		tokenString, _ := token.SignedString([]byte(cfg.JWT.Secret))
 		req, _ := http.NewRequest("GET", "/", nil)
@@ -85,3 +96,91 @@ func TestVerifyJWT(t *testing.T) {
 	})
 
 }
+
+func TestRefreshAndLogout(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cfg.JWT.Secret = "secret"
+	cont := controllers.InitController(db, cfg)
+
+	protected := cont.VerifyJWT(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	type tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decode := func(rr *httptest.ResponseRecorder) tokens {
+		var resp tokens
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return resp
+	}
+	call := func(token string) int {
+		req, _ := http.NewRequest("GET", "/protected/accounts", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		protected.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/refresh", strings.NewReader(`{"refresh_token": "`+refreshToken+`"}`))
+		rr := httptest.NewRecorder()
+		cont.HandleRefresh(rr, req)
+		return rr
+	}
+	login := func() tokens {
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"Email": "test@example.com", "Password": "password123"}`))
+		rr := httptest.NewRecorder()
+		cont.HandleLogin(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("login returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		return decode(rr)
+	}
+
+	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(`{"Email": "test@example.com", "Password": "password123"}`))
+	cont.HandleSignup(httptest.NewRecorder(), req)
+
+	first := login()
+	if code := call(first.Token); code != http.StatusOK {
+		t.Errorf("access token: got status %v want %v", code, http.StatusOK)
+	}
+
+	// The refresh token rotates and can only be used once
+	rr := refresh(first.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("refresh returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	second := decode(rr)
+	if second.RefreshToken == first.RefreshToken || call(second.Token) != http.StatusOK {
+		t.Errorf("refresh should issue a new working access token and refresh token")
+	}
+
+	// Replaying a used refresh token revokes the session it belongs to
+	if rr := refresh(first.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if code := call(second.Token); code != http.StatusUnauthorized {
+		t.Errorf("access token after refresh token reuse: got status %v want %v", code, http.StatusUnauthorized)
+	}
+
+	// Logging out stops the access and refresh tokens of the session only
+	current, other := login(), login()
+	req = httptest.NewRequest("POST", "/protected/logout", nil)
+	req.Header.Add("Authorization", "Bearer "+current.Token)
+	rr = httptest.NewRecorder()
+	cont.VerifyJWT(http.HandlerFunc(cont.HandleLogout)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("logout returned wrong status code: got %v want %v: %s", rr.Code, http.StatusNoContent, rr.Body.String())
+	}
+	if code := call(current.Token); code != http.StatusUnauthorized {
+		t.Errorf("access token after logout: got status %v want %v", code, http.StatusUnauthorized)
+	}
+	if rr := refresh(current.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token after logout: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if code := call(other.Token); code != http.StatusOK {
+		t.Errorf("other session after logout: got status %v want %v", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"stock-portfolio-api/models"
+)
+
+// Every handler that reads or changes account data goes through these helpers so queries are
+// scoped to the authenticated user and the role the handler needs: models.RoleViewer to read,
+// models.RoleEditor to change transactions and models.RoleOwner to change the account itself.
+// They write the error response and return false when the request may not continue.
+
+// authorizeAccount loads an account the authenticated user has at least the role on
+func (c *Controller) authorizeAccount(w http.ResponseWriter, r *http.Request, accountID uint, role string) (*models.User, *models.Account, bool) {
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	acct, err := models.FindUserAccount(c.db, u.ID, accountID)
+	if err != nil {
+		http.Error(w, "Unauthorized or account not found", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	if !models.RoleAllows(acct.Role, role) {
+		http.Error(w, "Insufficient access to account", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return u, acct, true
+}
+
+// authorizeAccountQuery loads the account named by the account_id query parameter
+func (c *Controller) authorizeAccountQuery(w http.ResponseWriter, r *http.Request, role string) (*models.User, *models.Account, bool) {
+	accountIDStr := r.URL.Query().Get("account_id")
+	if accountIDStr == "" {
+		http.Error(w, "account_id is required", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	accountID, err := strconv.Atoi(accountIDStr)
+	if err != nil {
+		http.Error(w, "Invalid account_id", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	return c.authorizeAccount(w, r, uint(accountID), role)
+}
+
+// authorizeTransaction loads a transaction in an account the authenticated user has at least
+// the role on
+func (c *Controller) authorizeTransaction(w http.ResponseWriter, r *http.Request, id uint, role string) (*models.User, *models.Transaction, bool) {
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	transaction, err := models.FindUserTransaction(c.db, u.ID, id)
+	if err != nil {
+		http.Error(w, "Transaction not found or unauthorized", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if !models.RoleAllows(transaction.Account.Role, role) {
+		http.Error(w, "Insufficient access to account", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return u, transaction, true
+}
+
+// authorizeImportJob loads an import job of an account the authenticated user has at least the
+// role on, so the owner and editors can see and undo each other's imports
+func (c *Controller) authorizeImportJob(w http.ResponseWriter, r *http.Request, id uint, role string) (*models.User, *models.ImportJob, bool) {
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	job, err := models.FindImportJobByID(c.db, id)
+	if err != nil {
+		http.Error(w, "Import not found or unauthorized", http.StatusNotFound)
+		return nil, nil, false
+	}
+	accountRole, err := models.AccountRole(c.db, u.ID, job.AccountID)
+	if err != nil {
+		http.Error(w, "Import not found or unauthorized", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if !models.RoleAllows(accountRole, role) {
+		http.Error(w, "Insufficient access to account", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return u, job, true
+}
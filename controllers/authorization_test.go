@@ -0,0 +1,106 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"stock-portfolio-api/config"
+	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+
+	"github.com/gorilla/mux"
+)
+
+// TestAccountIsolation checks that a user can't read or change the data of another user's account
+func TestAccountIsolation(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cfg.Import.DownloadPath = t.TempDir()
+	cont := controllers.InitController(db, cfg)
+
+	owner := models.User{Email: "owner@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&owner)
+	intruder := models.User{Email: "intruder@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&intruder)
+	account := models.Account{UserID: owner.ID, Name: "Owner Account"}
+	db.Create(&account)
+	db.Create(&models.Account{UserID: intruder.ID, Name: "Intruder Account"})
+
+	transaction := models.Transaction{AccountID: account.ID, Date: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), Action: "Buy", Symbol: "SECRET", Quantity: 100, Price: 10, Amount: -1000}
+	db.Create(&transaction)
+	if err := models.GeneratePositions(db, account.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target string) *http.Request {
+		return httptest.NewRequest("GET", target, nil)
+	}
+	withID := func(r *http.Request, id string) *http.Request {
+		return mux.SetURLVars(r, map[string]string{"id": id})
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		request *http.Request
+		status  int
+	}{
+		{"get account", cont.HandleGetAccount, withID(get("/protected/accounts/1"), "1"), http.StatusUnauthorized},
+		{"update account", cont.HandleUpdateAccount, withID(httptest.NewRequest("PATCH", "/protected/accounts/1", strings.NewReader(`{"name": "Mine"}`)), "1"), http.StatusUnauthorized},
+		{"delete account", cont.HandleDeleteAccount, withID(httptest.NewRequest("DELETE", "/protected/accounts/1", nil), "1"), http.StatusUnauthorized},
+		{"positions", cont.HandleGetPositions, get("/protected/positions?account_id=1"), http.StatusUnauthorized},
+		{"transactions", cont.HandleGetTransactions, get("/protected/transactions?account_id=1"), http.StatusUnauthorized},
+		{"export", cont.HandleExportTransactions, get("/protected/transactions/export?account_id=1"), http.StatusUnauthorized},
+		{"options income", cont.HandleGetOptionsIncome, get("/protected/reports/options-income?account_id=1"), http.StatusUnauthorized},
+		{"create transaction", cont.HandleCreateTransaction, httptest.NewRequest("POST", "/protected/transactions", strings.NewReader(`{"Date": "2024-01-05", "Action": "Sell", "Symbol": "SECRET", "Quantity": "100", "Price": "1", "FeesComm": "0", "Amount": "100", "AccountID": 1}`)), http.StatusUnauthorized},
+		{"update transaction", cont.HandleUpdateTransaction, withID(httptest.NewRequest("PATCH", "/protected/transactions/1", strings.NewReader(`{"Quantity": "1"}`)), "1"), http.StatusNotFound},
+		{"delete transaction", cont.HandleDeleteTransaction, withID(httptest.NewRequest("DELETE", "/protected/transactions/1", nil), "1"), http.StatusNotFound},
+		{"import", cont.HandleImport, importRequest(t, map[string]string{"account_id": "1"}, "schwab.json", `{"BrokerageTransactions": []}`), http.StatusUnauthorized},
+		// Without a transaction of its own the intruder gets no start date for the history
+		{"quote history", cont.HandleHistoricalPrices, get("/protected/quotes?symbol=SECRET"), http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tt.handler(rr, withUser(tt.request, intruder.ID))
+			if rr.Code != tt.status {
+				t.Errorf("got status %v want %v: %s", rr.Code, tt.status, rr.Body.String())
+			}
+			if strings.Contains(rr.Body.String(), "SECRET") {
+				t.Errorf("response leaks the other user's data: %s", rr.Body.String())
+			}
+		})
+	}
+
+	// Consolidated positions only include the intruder's own accounts
+	rr := httptest.NewRecorder()
+	cont.HandleGetConsolidatedPositions(rr, withUser(get("/protected/positions/consolidated"), intruder.ID))
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "SECRET") {
+		t.Errorf("got %v %s want no positions of the other user", rr.Code, rr.Body.String())
+	}
+
+	// Nothing was changed
+	stored, err := models.FindTransactionByID(db, transaction.ID)
+	if err != nil || stored.Quantity != 100 {
+		t.Errorf("got %+v %v want the owner's transaction unchanged", stored, err)
+	}
+	if stored, err := models.FindAccountByID(db, account.ID); err != nil || stored.Name != "Owner Account" {
+		t.Errorf("got %+v %v want the owner's account unchanged", stored, err)
+	}
+	var count int64
+	db.Model(&models.Transaction{}).Where("account_id = ?", account.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("got %v transactions on the owner's account want 1", count)
+	}
+
+	// The owner still sees their positions
+	rr = httptest.NewRecorder()
+	cont.HandleGetPositions(rr, withUser(get("/protected/positions?account_id=1"), owner.ID))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "SECRET") {
+		t.Errorf("got %v %s want the owner's positions", rr.Code, rr.Body.String())
+	}
+}
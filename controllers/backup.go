@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"stock-portfolio-api/models"
+)
+
+// HandleGetBackup handles downloading everything stored for the user as one JSON archive
+func (c *Controller) HandleGetBackup(w http.ResponseWriter, r *http.Request) {
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	backup, err := models.BuildBackup(c.db, u.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("backup-%s.json", time.Now().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(backup)
+}
+
+// HandleRestoreBackup handles restoring a backup archive into the user as new accounts
+func (c *Controller) HandleRestoreBackup(w http.ResponseWriter, r *http.Request) {
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	var backup models.Backup
+	r.Body = http.MaxBytesReader(w, r.Body, c.cfg.MaxUploadSize())
+	if err := json.NewDecoder(r.Body).Decode(&backup); err != nil {
+		http.Error(w, "Invalid backup archive", http.StatusBadRequest)
+		return
+	}
+
+	accountIDs, err := models.RestoreBackup(c.db, u.ID, &backup)
+	var invalid *models.InvalidBackupError
+	if errors.As(err, &invalid) {
+		http.Error(w, invalid.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, id := range accountIDs {
+		c.audit(r, u, models.AuditBackupRestore, id, id, nil, map[string]interface{}{"backup_created_at": backup.CreatedAt})
+	}
+
+	// The restore is committed at this point, so the error names the accounts that were created
+	for _, id := range accountIDs {
+		if err := models.GeneratePositions(c.db, id); err != nil {
+			http.Error(w, fmt.Sprintf("Backup restored into accounts %v but generating positions for account %d failed: %v", accountIDs, id, err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"accounts": accountIDs,
+	})
+}
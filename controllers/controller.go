@@ -2,18 +2,26 @@ package controllers
 
 import (
 	"stock-portfolio-api/config"
+	"stock-portfolio-api/mailer"
 
 	"gorm.io/gorm"
 )
 
 type Controller struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db     *gorm.DB
+	cfg    *config.Config
+	mailer mailer.Mailer
 }
 
 func InitController(db *gorm.DB, cfg *config.Config) *Controller {
 	return &Controller{
-		db:  db,
-		cfg: cfg,
+		db:     db,
+		cfg:    cfg,
+		mailer: mailer.FromConfig(cfg),
 	}
 }
+
+// SetMailer replaces the mailer chosen from the config
+func (c *Controller) SetMailer(m mailer.Mailer) {
+	c.mailer = m
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"stock-portfolio-api/models"
+)
+
+// TokenRequest carries a token from a mailed link
+type TokenRequest struct {
+	Token string `json:"token"`
+}
+
+// ForgotPasswordRequest asks for a password reset mail
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest sets a new password with a mailed reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// mailLink is the web app link for a mailed token, or the token itself when no app URL is configured
+func (c Controller) mailLink(path, token string) string {
+	if c.cfg.Mail.AppURL == "" {
+		return token
+	}
+	return fmt.Sprintf("%s%s?token=%s", c.cfg.Mail.AppURL, path, url.QueryEscape(token))
+}
+
+// sendVerificationEmail mails the user a link to verify their email address
+func (c Controller) sendVerificationEmail(user *models.User) error {
+	token, err := models.CreateUserToken(c.db, user.ID, models.TokenVerifyEmail, models.VerifyEmailTokenTTL)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Please verify your email address:\n\n%s\n\nThe link expires in 48 hours.\n", c.mailLink("/verify-email", token))
+	return c.mailer.Send(user.Email, "Verify your email address", body)
+}
+
+// HandleVerifyEmail handles verifying an email address with the token mailed on signup
+func (c Controller) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := models.VerifyEmail(c.db, req.Token); err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleResendVerification handles mailing a new verification link to the user
+func (c Controller) HandleResendVerification(w http.ResponseWriter, r *http.Request) {
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+	if u.EmailVerifiedAt != nil {
+		http.Error(w, "Email is already verified", http.StatusConflict)
+		return
+	}
+
+	if err := c.sendVerificationEmail(u); err != nil {
+		log.Println("Send verification email: ", err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// HandleForgotPassword handles mailing a password reset link. The response is the same whether
+// or not the email belongs to a user so it can't be used to find registered addresses.
+func (c Controller) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	if user, err := models.FindUserByEmail(c.db, req.Email); err == nil {
+		token, err := models.CreateUserToken(c.db, user.ID, models.TokenResetPassword, models.ResetPasswordTokenTTL)
+		if err != nil {
+			http.Error(w, "Failed to create reset token", http.StatusInternalServerError)
+			return
+		}
+		body := fmt.Sprintf("Someone asked to reset your password. If it was you, use this link:\n\n%s\n\nThe link expires in 1 hour. If it wasn't you, you can ignore this mail.\n", c.mailLink("/reset-password", token))
+		if err := c.mailer.Send(user.Email, "Reset your password", body); err != nil {
+			log.Println("Send password reset email: ", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// HandleResetPassword handles setting a new password with a mailed reset token
+func (c Controller) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Token == "" || req.Password == "" {
+		http.Error(w, "Token and Password is required", http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := models.ResetPassword(c.db, req.Token, string(passwordHash)); err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
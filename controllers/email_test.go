@@ -0,0 +1,109 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"stock-portfolio-api/config"
+	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+)
+
+type sentMail struct {
+	to, subject, body string
+}
+
+// recordingMailer keeps mail instead of sending it
+type recordingMailer struct {
+	sent []sentMail
+}
+
+func (m *recordingMailer) Send(to, subject, body string) error {
+	m.sent = append(m.sent, sentMail{to, subject, body})
+	return nil
+}
+
+// token pulls the token out of the link in the last mail
+func (m *recordingMailer) token(t *testing.T) string {
+	if len(m.sent) == 0 {
+		t.Fatal("no mail was sent")
+	}
+	body := m.sent[len(m.sent)-1].body
+	start := strings.Index(body, "?token=")
+	if start < 0 {
+		t.Fatalf("no link in mail %q", body)
+	}
+	token, err := url.QueryUnescape(strings.Fields(body[start+len("?token="):])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestEmailVerificationAndPasswordReset(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cfg.JWT.Secret = "secret"
+	cfg.Mail.AppURL = "https://app.example.com"
+	cont := controllers.InitController(db, cfg)
+	mail := &recordingMailer{}
+	cont.SetMailer(mail)
+
+	post := func(handler http.HandlerFunc, body string) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	if code := post(cont.HandleSignup, `{"Email": "test@example.com", "Password": "password123"}`); code != http.StatusCreated {
+		t.Fatalf("signup: got status %v want %v", code, http.StatusCreated)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].to != "test@example.com" || !strings.Contains(mail.sent[0].body, "https://app.example.com/verify-email?token=") {
+		t.Fatalf("got mail %+v want a verification link to test@example.com", mail.sent)
+	}
+
+	verification := mail.token(t)
+	if code := post(cont.HandleVerifyEmail, `{"token": "`+verification+`"}`); code != http.StatusNoContent {
+		t.Errorf("verify: got status %v want %v", code, http.StatusNoContent)
+	}
+	if code := post(cont.HandleVerifyEmail, `{"token": "`+verification+`"}`); code != http.StatusBadRequest {
+		t.Errorf("verify with a used token: got status %v want %v", code, http.StatusBadRequest)
+	}
+	user, err := models.FindUserByEmail(db, "test@example.com")
+	if err != nil || user.EmailVerifiedAt == nil {
+		t.Errorf("got %+v %v want a verified user", user, err)
+	}
+
+	// Unknown addresses get the same response and no mail
+	if code := post(cont.HandleForgotPassword, `{"email": "nobody@example.com"}`); code != http.StatusAccepted || len(mail.sent) != 1 {
+		t.Errorf("forgot password for an unknown email: got status %v and %v mails want %v and 1", code, len(mail.sent), http.StatusAccepted)
+	}
+
+	// Only the latest reset mail works
+	post(cont.HandleForgotPassword, `{"email": "test@example.com"}`)
+	stale := mail.token(t)
+	if code := post(cont.HandleForgotPassword, `{"email": "test@example.com"}`); code != http.StatusAccepted {
+		t.Errorf("forgot password: got status %v want %v", code, http.StatusAccepted)
+	}
+	reset := mail.token(t)
+	if code := post(cont.HandleResetPassword, `{"token": "`+stale+`", "password": "stolen"}`); code != http.StatusBadRequest {
+		t.Errorf("reset with an older token: got status %v want %v", code, http.StatusBadRequest)
+	}
+	if code := post(cont.HandleResetPassword, `{"token": "`+reset+`", "password": "newpassword"}`); code != http.StatusNoContent {
+		t.Errorf("reset: got status %v want %v", code, http.StatusNoContent)
+	}
+	if code := post(cont.HandleResetPassword, `{"token": "`+reset+`", "password": "again"}`); code != http.StatusBadRequest {
+		t.Errorf("reset with a used token: got status %v want %v", code, http.StatusBadRequest)
+	}
+
+	if code := post(cont.HandleLogin, `{"Email": "test@example.com", "Password": "password123"}`); code == http.StatusOK {
+		t.Errorf("login with the old password should fail")
+	}
+	if code := post(cont.HandleLogin, `{"Email": "test@example.com", "Password": "newpassword"}`); code != http.StatusOK {
+		t.Errorf("login with the new password: got status %v want %v", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"stock-portfolio-api/models"
+
+	"github.com/gorilla/mux"
+)
+
+// HandleGetImport handles fetching the status and row results of an import job
+func (c *Controller) HandleGetImport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid import ID", http.StatusBadRequest)
+		return
+	}
+
+	_, job, ok := c.authorizeImportJob(w, r, uint(id), models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(job)
+}
+
+// HandleDeleteImport handles undoing an import by deleting the transactions it created
+func (c *Controller) HandleDeleteImport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid import ID", http.StatusBadRequest)
+		return
+	}
+
+	u, job, ok := c.authorizeImportJob(w, r, uint(id), models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if job.Status == models.ImportQueued || job.Status == models.ImportRunning {
+		http.Error(w, "Import is still in progress", http.StatusConflict)
+		return
+	}
+	if job.Status == models.ImportUndone {
+		http.Error(w, "Import has already been undone", http.StatusConflict)
+		return
+	}
+
+	before := models.ImportJobAuditValues(job)
+	deleted, err := models.UndoImportJob(c.db, job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.audit(r, u, models.AuditImportUndo, job.AccountID, job.ID, before, map[string]interface{}{"status": models.ImportUndone, "deleted_transactions": deleted})
+
+	// Recalculate position attributes after deleting the transactions
+	if err := models.GeneratePositions(c.db, job.AccountID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+
+	"stock-portfolio-api/importers"
+	"stock-portfolio-api/models"
+)
+
+// previewStatuses names the row outcomes the way the preview reports them
+var previewStatuses = map[string]string{
+	models.RowImported:           "new",
+	models.RowSkippedDuplicate:   "duplicate",
+	models.RowSkippedUnsupported: "unsupported",
+	models.RowFailed:             "failed",
+}
+
+// ImportPreviewFile is the dry-run result of a single uploaded file
+type ImportPreviewFile struct {
+	File   string       `json:"file"`
+	Format string       `json:"format,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	Rows   []rowOutcome `json:"rows"`
+}
+
+// previewImport parses the uploaded files and reports what importing them into the account
+// would do, nothing is written
+func (c *Controller) previewImport(w http.ResponseWriter, acct *models.Account, files []*multipart.FileHeader, imp importers.Importer) {
+	summary := map[string]int{"new": 0, "duplicate": 0, "unsupported": 0, "failed": 0}
+	previews := []ImportPreviewFile{}
+	for _, fileHeader := range files {
+		preview := ImportPreviewFile{File: fileHeader.Filename, Rows: []rowOutcome{}}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		classifier, err := newRowClassifier(c.db, acct.ID)
+		if err != nil {
+			file.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var rows []importers.Row
+		fileImp, err := parseImportFile(file, imp, func(row importers.Row) error {
+			rows = append(rows, row)
+			return nil
+		})
+		file.Close()
+		if fileImp != nil {
+			preview.Format = fileImp.Name()
+		}
+		if err != nil {
+			preview.Error = err.Error()
+			previews = append(previews, preview)
+			continue
+		}
+
+		outcomes, err := classifier.classify(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, outcome := range outcomes {
+			outcome.Status = previewStatuses[outcome.Status]
+			summary[outcome.Status]++
+			preview.Rows = append(preview.Rows, outcome)
+		}
+		previews = append(previews, preview)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files":   previews,
+		"summary": summary,
+	})
+}
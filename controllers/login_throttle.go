@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"stock-portfolio-api/models"
+)
+
+// failedLoginsLimit is how many failed logins the audit shows
+const failedLoginsLimit = 100
+
+// loginPolicy is the configured delay for failed logins
+func (c Controller) loginPolicy() models.LoginPolicy {
+	return models.LoginPolicy{
+		MaxAttempts:      c.cfg.LoginMaxAttempts(),
+		MaxAttemptsPerIP: c.cfg.LoginMaxAttemptsPerIP(),
+		BaseDelay:        time.Second,
+		Lockout:          c.cfg.LoginLockout(),
+	}
+}
+
+// clientIP is the address the request came from. The configured proxy header is only used when
+// it is set, the first address in it is the client.
+func (c Controller) clientIP(r *http.Request) string {
+	if c.cfg.Login.ClientIPHeader != "" {
+		if value := r.Header.Get(c.cfg.Login.ClientIPHeader); value != "" {
+			ip, _, _ := strings.Cut(value, ",")
+			return strings.TrimSpace(ip)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowLogin responds with 429 and returns false when the email or IP address has to wait
+// before trying again
+func (c Controller) allowLogin(w http.ResponseWriter, email, ip string) bool {
+	wait, err := c.loginPolicy().RetryAfter(c.db, email, ip, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if wait <= 0 {
+		return true
+	}
+
+	var user *models.User
+	if u, err := models.FindUserByEmail(c.db, email); err == nil {
+		user = u
+	}
+	c.recordLogin(email, ip, user, models.LoginThrottled)
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many login attempts, try again later", http.StatusTooManyRequests)
+	return false
+}
+
+// recordLogin stores a login attempt, the login goes on when it can't be stored
+func (c Controller) recordLogin(email, ip string, user *models.User, result string) {
+	var userID *uint
+	if user != nil {
+		userID = &user.ID
+	}
+	if err := models.RecordLoginAttempt(c.db, email, ip, userID, result); err != nil {
+		log.Println("Record login attempt: ", err)
+	}
+}
+
+// HandleGetFailedLogins handles listing recent failed logins to the user's account
+func (c Controller) HandleGetFailedLogins(w http.ResponseWriter, r *http.Request) {
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	attempts, err := models.FetchFailedLogins(c.db, u.ID, failedLoginsLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(attempts)
+}
@@ -0,0 +1,70 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"stock-portfolio-api/config"
+	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cfg.JWT.Secret = "secret"
+	cfg.Login.MaxAttempts = 3
+	cfg.Login.ClientIPHeader = "X-Real-IP"
+	cont := controllers.InitController(db, cfg)
+	cont.SetMailer(&recordingMailer{})
+
+	login := func(ip, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+		req.Header.Set("X-Real-IP", ip)
+		rr := httptest.NewRecorder()
+		cont.HandleLogin(rr, req)
+		return rr
+	}
+
+	cont.HandleSignup(httptest.NewRecorder(), httptest.NewRequest("POST", "/signup", strings.NewReader(`{"Email": "test@example.com", "Password": "password123"}`)))
+
+	for i := 0; i < 3; i++ {
+		if rr := login("10.0.0.1", `{"Email": "test@example.com", "Password": "wrong"}`); rr.Code != http.StatusNotFound {
+			t.Fatalf("failed login %d: got status %v want %v", i, rr.Code, http.StatusNotFound)
+		}
+	}
+
+	// The right password from another address waits too, the email is locked
+	rr := login("10.0.0.2", `{"Email": "test@example.com", "Password": "password123"}`)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("login after failures: got status %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if seconds, err := strconv.Atoi(rr.Header().Get("Retry-After")); err != nil || seconds < 1 {
+		t.Errorf("got Retry-After %q want a positive number of seconds", rr.Header().Get("Retry-After"))
+	}
+	if rr := login("10.0.0.2", `{"Email": "other@example.com", "Password": "password123"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("another email: got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	// The user can see the failed and refused logins once the wait is over
+	db.Model(&models.LoginAttempt{}).Where("1 = 1").Update("created_at", db.NowFunc().Add(-cfg.LoginLockout()))
+	rr = login("10.0.0.2", `{"Email": "test@example.com", "Password": "password123"}`)
+	var tokens map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&tokens)
+	if rr.Code != http.StatusOK || tokens["token"] == nil {
+		t.Fatalf("login after the lockout: got status %v want a token", rr.Code)
+	}
+
+	user, _ := models.FindUserByEmail(db, "test@example.com")
+	rr = httptest.NewRecorder()
+	cont.HandleGetFailedLogins(rr, withUser(httptest.NewRequest("GET", "/protected/login-attempts", nil), user.ID))
+	var attempts []models.LoginAttempt
+	json.NewDecoder(rr.Body).Decode(&attempts)
+	if len(attempts) != 4 || attempts[0].Result != models.LoginThrottled || attempts[0].IP != "10.0.0.2" || attempts[3].Result != models.LoginBadPassword {
+		t.Errorf("got failed logins %+v want 3 bad passwords and a throttled login", attempts)
+	}
+}
@@ -3,36 +3,44 @@ package controllers
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
-
-	"gorm.io/gorm"
 
 	"stock-portfolio-api/models"
 )
 
 // HandleGetPositions handles fetching positions for a specific account ID
 func (c *Controller) HandleGetPositions(w http.ResponseWriter, r *http.Request) {
-	accountIDStr := r.URL.Query().Get("account_id")
-	if accountIDStr == "" {
-		http.Error(w, "account_id is required", http.StatusBadRequest)
+	_, acct, ok := c.authorizeAccountQuery(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	positions, err := models.FetchPositionsByAccount(c.db, acct.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	accountID, err := strconv.Atoi(accountIDStr)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(positions)
+}
+
+// HandleGetConsolidatedPositions handles fetching the open positions of all accounts the user
+// owns or has shared with them, merged by symbol
+func (c *Controller) HandleGetConsolidatedPositions(w http.ResponseWriter, r *http.Request) {
+	u, err := userFromRequestContext(r, c.db)
 	if err != nil {
-		http.Error(w, "Invalid account_id", http.StatusBadRequest)
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
 		return
 	}
 
-	positions, err := models.FetchPositionsByAccount(c.db, uint(accountID))
+	positions, err := models.ConsolidatedPositions(c.db, u.ID, models.GetCurrentPrices)
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			http.Error(w, "Account not found", http.StatusNotFound)
-			return
-		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(positions)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"positions": positions,
+	})
 }
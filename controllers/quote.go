@@ -34,9 +34,15 @@ func (c *Controller) HandleHistoricalPrices(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Fetch the first transaction of the position
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	// Fetch the user's first transaction of the position
 	var transaction models.Transaction
-	err := c.db.Where("symbol = ?", symbol).Order("date ASC").First(&transaction).Error
+	err = c.db.Scopes(models.UserAccounts(u.ID)).Where("symbol = ?", symbol).Order("date ASC").First(&transaction).Error
 	if err != nil {
 		http.Error(w, "failed to fetch transaction", http.StatusNoContent)
 		return
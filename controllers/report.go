@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"stock-portfolio-api/models"
+)
+
+// HandleGetOptionsIncome handles the option premium income report for a specific account ID
+func (c *Controller) HandleGetOptionsIncome(w http.ResponseWriter, r *http.Request) {
+	_, acct, ok := c.authorizeAccountQuery(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	report, err := models.OptionPremiumReport(c.db, acct.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"income": report,
+	})
+}
@@ -2,7 +2,10 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -24,6 +27,12 @@ type CreateTransactionRequest struct {
 	AccountID   uint   `json:"AccountID" binding:"required"`
 }
 
+// Helper function to parse monetary values by removing $ and ,
+func parseMonetaryValue(value string) (float64, error) {
+	cleanedValue := strings.ReplaceAll(strings.ReplaceAll(value, "$", ""), ",", "")
+	return strconv.ParseFloat(cleanedValue, 64)
+}
+
 // HandleCreateTransaction handles the creation of a new transaction
 func (c *Controller) HandleCreateTransaction(w http.ResponseWriter, r *http.Request) {
 	var req CreateTransactionRequest
@@ -32,21 +41,8 @@ func (c *Controller) HandleCreateTransaction(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Helper function to parse monetary values by removing $ and ,
-	parseMonetaryValue := func(value string) (float64, error) {
-		cleanedValue := strings.ReplaceAll(strings.ReplaceAll(value, "$", ""), ",", "")
-		return strconv.ParseFloat(cleanedValue, 64)
-	}
-
-	u, err := userFromRequestContext(r, c.db)
-	if err != nil {
-		http.Error(w, "Unable to find user", http.StatusUnauthorized)
-		return
-	}
-
-	acct, err := models.FindAccountByID(c.db, req.AccountID)
-	if err != nil || acct.UserID != u.ID {
-		http.Error(w, "Unauthorized or account not found", http.StatusUnauthorized)
+	u, acct, ok := c.authorizeAccount(w, r, req.AccountID, models.RoleEditor)
+	if !ok {
 		return
 	}
 
@@ -102,6 +98,7 @@ func (c *Controller) HandleCreateTransaction(w http.ResponseWriter, r *http.Requ
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	c.audit(r, u, models.AuditTransactionCreate, acct.ID, transaction.ID, nil, models.TransactionAuditValues(transaction))
 
 	// Recalculate position attributes after deleting the transaction
 	if err := models.GeneratePositions(c.db, transaction.AccountID); err != nil {
@@ -113,36 +110,88 @@ func (c *Controller) HandleCreateTransaction(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(transaction)
 }
 
+// transactionFilterFromQuery reads the transaction filters shared by listing and exporting
+func transactionFilterFromQuery(query url.Values) (models.TransactionFilter, error) {
+	filter := models.TransactionFilter{
+		Symbol:     query.Get("symbol"),
+		Underlying: query.Get("underlying"),
+		Search:     query.Get("q"),
+	}
+	if action := query.Get("action"); action != "" {
+		filter.Actions = strings.Split(action, ",")
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.ParseInLocation("2006-01-02", from, time.Local); err != nil {
+			return filter, fmt.Errorf("Invalid from date")
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.ParseInLocation("2006-01-02", to, time.Local); err != nil {
+			return filter, fmt.Errorf("Invalid to date")
+		}
+	}
+	if min := query.Get("min_amount"); min != "" {
+		value, err := parseMonetaryValue(min)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid min_amount")
+		}
+		filter.MinAmount = &value
+	}
+	if max := query.Get("max_amount"); max != "" {
+		value, err := parseMonetaryValue(max)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid max_amount")
+		}
+		filter.MaxAmount = &value
+	}
+	return filter, nil
+}
+
 // HandleGetTransactions handles fetching transactions for a specific account ID
 func (c *Controller) HandleGetTransactions(w http.ResponseWriter, r *http.Request) {
-	accountIDStr := r.URL.Query().Get("account_id")
-	if accountIDStr == "" {
-		http.Error(w, "account_id is required", http.StatusBadRequest)
+	_, acct, ok := c.authorizeAccountQuery(w, r, models.RoleViewer)
+	if !ok {
 		return
 	}
-	accountID, err := strconv.Atoi(accountIDStr)
+
+	filter, err := transactionFilterFromQuery(r.URL.Query())
 	if err != nil {
-		http.Error(w, "Invalid account_id", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	symbol := r.URL.Query().Get("symbol")
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page <= 0 {
-		page = 1
+	query := models.TransactionQuery{
+		Filter: filter,
+		Sort:   r.URL.Query().Get("sort"),
+		Desc:   r.URL.Query().Get("order") != "asc",
+		Cursor: r.URL.Query().Get("cursor"),
+	}
+	query.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+	query.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	if query.Limit <= 0 {
+		query.Limit = 10
 	}
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	if limit <= 0 {
-		limit = 10
+	if query.Limit > 500 {
+		query.Limit = 500
 	}
 
-	transactions, err := models.FetchTransactionsByAccountIDAndSymbol(c.db, uint(accountID), symbol, page, limit)
+	transactions, nextCursor, err := models.FetchTransactions(c.db, acct.ID, query)
+	var invalid *models.InvalidTransactionQueryError
+	if errors.As(err, &invalid) {
+		http.Error(w, invalid.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	total, err := models.CountTransactionsByAccountIDAndSymbol(c.db, uint(accountID), symbol)
+	total, err := models.CountTransactions(c.db, acct.ID, filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -152,6 +201,7 @@ func (c *Controller) HandleGetTransactions(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"transactions": transactions,
 		"total":        total,
+		"next_cursor":  nextCursor,
 	})
 }
 
@@ -164,28 +214,123 @@ func (c *Controller) HandleDeleteTransaction(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	u, err := userFromRequestContext(r, c.db)
+	u, transaction, ok := c.authorizeTransaction(w, r, uint(id), models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteTransaction(c.db, transaction.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.audit(r, u, models.AuditTransactionDelete, transaction.AccountID, transaction.ID, models.TransactionAuditValues(transaction), nil)
+
+	// Recalculate position attributes after deleting the transaction
+	if err := models.GeneratePositions(c.db, transaction.AccountID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateTransactionRequest holds the fields to change, fields left out keep their value
+type UpdateTransactionRequest struct {
+	Date        *string `json:"Date"`
+	Action      *string `json:"Action"`
+	Symbol      *string `json:"Symbol"`
+	Description *string `json:"Description"`
+	Quantity    *string `json:"Quantity"`
+	Price       *string `json:"Price"`
+	FeesComm    *string `json:"FeesComm"`
+	Amount      *string `json:"Amount"`
+}
+
+// HandleUpdateTransaction handles correcting a transaction by ID
+func (c *Controller) HandleUpdateTransaction(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	transaction, err := models.FindTransactionByID(c.db, uint(id))
-	if err != nil || transaction == nil || transaction.Account.UserID != u.ID {
-		http.Error(w, "Transaction not found or unauthorized", http.StatusNotFound)
+	u, transaction, ok := c.authorizeTransaction(w, r, uint(id), models.RoleEditor)
+	if !ok {
 		return
 	}
+	before := models.TransactionAuditValues(transaction)
 
-	if err := models.DeleteTransaction(c.db, uint(id)); err != nil {
+	if req.Date != nil {
+		if transaction.Date, err = time.Parse("2006-01-02", *req.Date); err != nil {
+			http.Error(w, "Invalid Date", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Action != nil {
+		if *req.Action == "" {
+			http.Error(w, "Invalid Action", http.StatusBadRequest)
+			return
+		}
+		transaction.Action = *req.Action
+	}
+	if req.Symbol != nil {
+		if *req.Symbol == "" {
+			http.Error(w, "Invalid Symbol", http.StatusBadRequest)
+			return
+		}
+		transaction.Symbol = *req.Symbol
+	}
+	if req.Description != nil {
+		transaction.Description = *req.Description
+	}
+
+	values := []struct {
+		value *string
+		field *float64
+		name  string
+	}{
+		{req.Quantity, &transaction.Quantity, "Quantity"},
+		{req.Price, &transaction.Price, "Price"},
+		{req.FeesComm, &transaction.Fees, "Fees & Comm"},
+		{req.Amount, &transaction.Amount, "Amount"},
+	}
+	for _, v := range values {
+		if v.value == nil {
+			continue
+		}
+		parsed, err := parseMonetaryValue(*v.value)
+		if err != nil {
+			http.Error(w, "Invalid "+v.name, http.StatusBadRequest)
+			return
+		}
+		*v.field = parsed
+	}
+
+	if err := models.UpdateTransaction(c.db, transaction); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	c.audit(r, u, models.AuditTransactionUpdate, transaction.AccountID, transaction.ID, before, models.TransactionAuditValues(transaction))
 
-	// Recalculate position attributes after deleting the transaction
+	// Recalculate position attributes after updating the transaction
 	if err := models.GeneratePositions(c.db, transaction.AccountID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	updated, err := models.FindTransactionByID(c.db, transaction.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updated)
 }
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"stock-portfolio-api/importers"
+	"stock-portfolio-api/models"
+)
+
+// HandleExportTransactions handles exporting the transactions of an account as CSV or JSON in the
+// Schwab layout, so the export can be imported into another account
+func (c *Controller) HandleExportTransactions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "Invalid format, expected csv or json", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := transactionFilterFromQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, acct, ok := c.authorizeAccountQuery(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	transactions, err := models.FindTransactions(c.db, acct.ID, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("transactions-%d.%s", acct.ID, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		err = importers.WriteSchwabJSON(w, transactions)
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+		err = importers.WriteSchwabCSV(w, transactions)
+	}
+	if err != nil {
+		log.Println("Export transactions: ", err)
+	}
+}
@@ -0,0 +1,59 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"stock-portfolio-api/config"
+	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+)
+
+func TestHandleExportTransactions(t *testing.T) {
+	db := setupDB(t)
+	cont := controllers.InitController(db, &config.Config{})
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&user)
+	account := models.Account{UserID: user.ID, Name: "Test Account"}
+	db.Create(&account)
+	other := models.User{Email: "other@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&other)
+
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.Local) }
+	db.Create(&[]models.Transaction{
+		{AccountID: account.ID, Date: day(5), Action: "Buy", Symbol: "AAPL", Description: "APPLE INC", Quantity: 100, Price: 180, Amount: -18000},
+		{AccountID: account.ID, Date: day(8), Action: "Sell to Open", Symbol: "AAPL 02/16/2024 200.00 C", Description: "CALL APPLE INC", Quantity: -1, Price: 2.1, Fees: 0.66, Amount: 209.34},
+		{AccountID: account.ID, Date: day(9), Action: "Buy", Symbol: "MSFT", Description: "MICROSOFT CORP", Quantity: 10, Price: 370, Amount: -3700},
+	})
+
+	req := withUser(httptest.NewRequest("GET", "/protected/transactions/export?account_id=1&underlying=AAPL&from=2024-01-06", nil), user.ID)
+	rr := httptest.NewRecorder()
+	cont.HandleExportTransactions(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	expected := "Date,Action,Symbol,Description,Quantity,Price,Fees & Comm,Amount\n" +
+		"01/08/2024,Sell to Open,AAPL 02/16/2024 200.00 C,CALL APPLE INC,1,$2.10,$0.66,$209.34\n"
+	if rr.Body.String() != expected {
+		t.Errorf("got export %q want %q", rr.Body.String(), expected)
+	}
+
+	req = withUser(httptest.NewRequest("GET", "/protected/transactions/export?account_id=1&format=json", nil), user.ID)
+	rr = httptest.NewRecorder()
+	cont.HandleExportTransactions(rr, req)
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), `"Action"`) != 3 {
+		t.Errorf("got %v %s want all 3 transactions as JSON", rr.Code, rr.Body.String())
+	}
+
+	// Another user's account can't be exported
+	req = withUser(httptest.NewRequest("GET", "/protected/transactions/export?account_id=1", nil), other.ID)
+	rr = httptest.NewRecorder()
+	cont.HandleExportTransactions(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
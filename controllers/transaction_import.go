@@ -1,36 +1,28 @@
 package controllers
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
-	"time"
 
+	"stock-portfolio-api/importers"
 	"stock-portfolio-api/models"
 
 	"gorm.io/gorm"
 )
 
-const MAX_UPLOAD_SIZE = 1024 * 1024 // 1MB
-
-// HandleImport handles the import of transactions from a JSON file
+// HandleImport handles the import of transactions from brokerage export files
 func (c *Controller) HandleImport(w http.ResponseWriter, r *http.Request) {
 	log.Println("Handling import")
 
-	u, err := userFromRequestContext(r, c.db)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Unable to find user", http.StatusUnauthorized)
-		return
-	}
-
 	// Parse form data
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -50,218 +42,381 @@ func (c *Controller) HandleImport(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate account ownership
-	acct, err := models.FindAccountByID(c.db, uint(accountID))
-	if err != nil || acct.UserID != u.ID {
-		http.Error(w, "Unauthorized or account not found", http.StatusUnauthorized)
+	u, acct, ok := c.authorizeAccount(w, r, uint(accountID), models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	// The format is detected from each file unless one is given
+	imp, err := importerFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Get a reference to the fileHeaders
 	files := r.MultipartForm.File["file"]
-	uploadedFiles := []string{}
 	for _, fileHeader := range files {
-		if fileHeader.Size > MAX_UPLOAD_SIZE {
-			http.Error(w, fmt.Sprintf("The uploaded file is too big: %s. Please use a file less than 1MB in size", fileHeader.Filename), http.StatusBadRequest)
-			return
-		}
-
-		file, err := fileHeader.Open()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if fileHeader.Size > c.cfg.MaxUploadSize() {
+			http.Error(w, fmt.Sprintf("The uploaded file is too big: %s. Please use a file less than %dMB in size", fileHeader.Filename, c.cfg.MaxUploadSize()>>20), http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
+	}
 
-		err = os.MkdirAll("./uploads", os.ModePerm)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// A preview parses the files and reports what would be imported without writing anything
+	if r.FormValue("preview") == "true" {
+		c.previewImport(w, acct, files, imp)
+		return
+	}
 
-		f, err := os.Create(fmt.Sprintf("./uploads/%s", fileHeader.Filename))
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		defer f.Close()
+	format := ""
+	if imp != nil {
+		format = imp.Name()
+	}
+	job, err := models.CreateImportJob(c.db, u.ID, acct.ID, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		_, err = io.Copy(f, file)
+	// Each job gets its own directory so concurrent uploads never see each other's files
+	dir := filepath.Join(c.cfg.ImportPath(), strconv.Itoa(int(job.ID)))
+	uploadedFiles := []string{}
+	for i, fileHeader := range files {
+		name, err := saveUpload(fileHeader, dir, i)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			os.RemoveAll(dir)
+			job.Finish(c.db, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		uploadedFiles = append(uploadedFiles, fileHeader.Filename)
+		uploadedFiles = append(uploadedFiles, name)
 	}
 
-	// Kick off the import into MySQL
-	go importUploadedJSONFiles(c.db, uint(accountID))
+	c.audit(r, u, models.AuditImportStart, acct.ID, job.ID, nil, map[string]interface{}{"files": uploadedFiles, "format": format})
 
-	// Send the uploaded files as a response
+	// Send the uploaded files and the job to poll as a response
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"files": uploadedFiles,
+		"job":   job,
 	})
+
+	// Kick off the import into MySQL
+	go importUploadedFiles(c.db, job, dir, imp)
 }
 
-// importUploadedJSONFiles reads uploaded JSON files and imports transactions
-func importUploadedJSONFiles(db *gorm.DB, accountID uint) {
-	files, err := os.ReadDir("./uploads")
+// saveUpload copies the index-th uploaded file into dir and returns the name it was stored under
+func saveUpload(fileHeader *multipart.FileHeader, dir string, index int) (string, error) {
+	file, err := fileHeader.Open()
 	if err != nil {
-		log.Println("Error reading uploads directory:", err)
-		return
+		return "", err
 	}
+	defer file.Close()
 
-	// Get the last imported transaction date for the account
-	lastTransactionDate, err := models.GetLastTransactionDate(db, accountID)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	// Only keep the base name so a crafted filename can't escape the job directory
+	name := filepath.Base(filepath.Clean("/" + fileHeader.Filename))
+	if name == "/" || name == "." {
+		name = "upload"
+	}
+	// Files from different folders can share a name, the index keeps them apart and in upload order
+	name = fmt.Sprintf("%03d-%s", index+1, name)
+	f, err := os.Create(filepath.Join(dir, name))
 	if err != nil {
-		log.Println("Error retrieving last transaction date:", err)
-		return
+		return "", err
 	}
+	defer f.Close()
 
-	for _, file := range files {
-		if file.Type().IsRegular() && filepath.Ext(file.Name()) == ".json" {
-			filename := filepath.Join("./uploads", file.Name())
-			importFile(filename, db, accountID, lastTransactionDate)
-			if e := os.Remove(filename); e != nil {
-				log.Println(err)
-			}
+	if _, err := io.Copy(f, file); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// importerFromRequest returns the importer for the "format" form value, a "mapping" form value
+// configures the generic CSV importer. A nil importer means the format is detected per file.
+func importerFromRequest(r *http.Request) (importers.Importer, error) {
+	format := r.FormValue("format")
+	if mapping := r.FormValue("mapping"); mapping != "" {
+		if format != "" && format != "csv" {
+			return nil, fmt.Errorf("mapping is only supported for the csv format")
+		}
+		var m importers.ColumnMapping
+		if err := json.Unmarshal([]byte(mapping), &m); err != nil {
+			return nil, fmt.Errorf("Invalid mapping: %v", err)
 		}
+		return importers.NewGenericCSV(m), nil
+	}
+	if format == "" {
+		return nil, nil
+	}
+	imp, ok := importers.Get(format)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported format %q, expected one of %s", format, strings.Join(importers.Formats(), ", "))
 	}
+	return imp, nil
 }
 
-func importFile(filePath string, db *gorm.DB, accountID uint, lastTransactionDate time.Time) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Println("Error opening file:", err)
-		return
+// importUploadedFiles imports the files in the job's upload directory, recording the outcome on the job
+func importUploadedFiles(db *gorm.DB, job *models.ImportJob, dir string, imp importers.Importer) {
+	defer os.RemoveAll(dir)
+
+	if err := job.Start(db); err != nil {
+		log.Println("Error starting import job:", err)
 	}
-	defer file.Close()
 
-	// Define a structure that matches the JSON file
-	type BrokerageTransaction struct {
-		Date        string `json:"Date"`
-		Action      string `json:"Action"`
-		Symbol      string `json:"Symbol"`
-		Description string `json:"Description"`
-		Quantity    string `json:"Quantity"`
-		Price       string `json:"Price"`
-		FeesComm    string `json:"Fees & Comm"`
-		Amount      string `json:"Amount"`
+	err := importFiles(db, job, dir, imp)
+	if err != nil {
+		log.Println("Import failed:", err)
+	}
+	if err := job.Finish(db, err); err != nil {
+		log.Println("Error saving import job:", err)
 	}
 
-	type TransactionsFile struct {
-		BrokerageTransactions []BrokerageTransaction `json:"BrokerageTransactions"`
+	// The import runs after the request, it is recorded for the user who uploaded the files
+	entry := &models.AuditEntry{UserID: job.UserID, Action: models.AuditImportFinish, AccountID: &job.AccountID, EntityID: job.ID}
+	if err := models.RecordAudit(db, entry, nil, models.ImportJobAuditValues(job)); err != nil {
+		log.Println("Record audit entry: ", err)
 	}
+}
 
-	var transactionsFile TransactionsFile
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&transactionsFile); err != nil {
-		log.Println("Error decoding JSON file:", err)
-		return
+func importFiles(db *gorm.DB, job *models.ImportJob, dir string, imp importers.Importer) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading uploads directory: %v", err)
 	}
 
-	// Define the allowed actions
-	allowedActions := map[string]bool{
-		"Buy to Open":          true,
-		"Buy to Close":         true,
-		"Sell to Open":         true,
-		"Sell to Close":        true,
-		"Buy":                  true,
-		"Sell":                 true,
-		"Sell Short":           true,
-		"Assigned":             true,
-		"Expired":              true,
-		"Options Frwd Split":   true,
-		"Stock Split":          true,
-		"Reverse Split":        true,
-		"Exchange or Exercise": true,
-	}
-
-	var transactions []models.Transaction
-	for _, bt := range transactionsFile.BrokerageTransactions {
-		if !allowedActions[bt.Action] {
-			continue
+	var failed []string
+	for _, file := range files {
+		if file.Type().IsRegular() {
+			filename := filepath.Join(dir, file.Name())
+			if err := importFile(filename, db, job, imp); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", file.Name(), err))
+			}
 		}
+	}
 
-		// Extract the correct date from the Date field
-		transactionDateStr := extractCorrectDate(bt.Date)
-		transactionDate, err := time.Parse("01/02/2006", transactionDateStr)
-		if err != nil {
-			log.Println("Error parsing transaction date:", err)
-			continue
+	// Generate positions after creating the transactions
+	if job.Imported > 0 {
+		if err := models.GeneratePositions(db, job.AccountID); err != nil {
+			return fmt.Errorf("generating positions: %v", err)
 		}
+	}
 
-		// Convert to local time zone at the beginning of the day
-		location, _ := time.LoadLocation("Local")
-		transactionDate = time.Date(transactionDate.Year(), transactionDate.Month(), transactionDate.Day(), 0, 0, 0, 0, location)
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
 
-		// Skip transactions that are older than or equal to the last transaction date
-		if transactionDate.Before(lastTransactionDate) {
-			continue
-		}
+// allowedActions are the actions that are imported, other rows are skipped as unsupported
+var allowedActions = map[string]bool{
+	"Buy to Open":          true,
+	"Buy to Close":         true,
+	"Sell to Open":         true,
+	"Sell to Close":        true,
+	"Buy":                  true,
+	"Sell":                 true,
+	"Sell Short":           true,
+	"Assigned":             true,
+	"Expired":              true,
+	"Options Frwd Split":   true,
+	"Stock Split":          true,
+	"Reverse Split":        true,
+	"Exchange or Exercise": true,
+	"Reinvest Dividend":    true,
+	"Reinvest Shares":      true,
+	// Dividend cash rows are kept for the income reports, positions skip them
+	"Qualified Dividend": true,
+	"Cash Dividend":      true,
+	"Non-Qualified Div":  true,
+}
 
-		quantity, _ := parseMonetaryValue(bt.Quantity)
-		price, _ := parseMonetaryValue(bt.Price)
-		fees, _ := parseMonetaryValue(bt.FeesComm)
-		amount, _ := parseMonetaryValue(bt.Amount)
+// importBatchSize is how many parsed rows are classified and inserted together
+const importBatchSize = 1000
 
-		// Check for existing transaction on the same date
-		var existingTransaction models.Transaction
-		err = db.Where("account_id = ? AND date = ? AND action = ? AND symbol = ? AND description = ? AND quantity = ? AND price = ? AND fees = ? AND amount = ?", accountID, transactionDate, bt.Action, bt.Symbol, bt.Description, quantity, price, fees, amount).First(&existingTransaction).Error
-		if err == nil {
-			// Transaction already exists, skip it
-			continue
+// importFile streams the rows of a file in batches and inserts the new ones inside a single
+// database transaction, so a file is either fully imported or not at all
+func importFile(filePath string, db *gorm.DB, job *models.ImportJob, imp importers.Importer) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	name := filepath.Base(filePath)
+
+	// Row outcomes are only recorded on the job once the transaction commits
+	var recorded []rowOutcome
+	err = db.Transaction(func(tx *gorm.DB) error {
+		classifier, err := newRowClassifier(tx, job.AccountID)
+		if err != nil {
+			return err
 		}
 
-		// Create a new transaction
-		transaction := models.Transaction{
-			Date:        transactionDate,
-			Action:      bt.Action,
-			Symbol:      bt.Symbol,
-			Description: bt.Description,
-			Quantity:    quantity,
-			Price:       price,
-			Fees:        fees,
-			Amount:      amount,
-			AccountID:   accountID,
+		batch := make([]importers.Row, 0, importBatchSize)
+		flush := func() error {
+			outcomes, err := classifier.classify(batch)
+			if err != nil {
+				return err
+			}
+			batch = batch[:0]
+
+			var created []rowOutcome
+			var transactions []models.Transaction
+			for _, outcome := range outcomes {
+				if outcome.Status != models.RowImported {
+					recorded = append(recorded, outcome)
+					continue
+				}
+				// Tag with the job so the batch can be undone
+				outcome.Transaction.ImportJobID = &job.ID
+				created = append(created, outcome)
+				transactions = append(transactions, outcome.Transaction)
+			}
+			if len(transactions) == 0 {
+				return nil
+			}
+			if err := tx.CreateInBatches(transactions, 500).Error; err != nil {
+				return err
+			}
+			recorded = append(recorded, created...)
+			return nil
 		}
-		transactions = append(transactions, transaction)
-	}
 
-	if len(transactions) == 0 {
-		log.Println("Nothing to import")
-		return
+		_, err = parseImportFile(file, imp, func(row importers.Row) error {
+			batch = append(batch, row)
+			if len(batch) == importBatchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
 	}
 
-	// Sort transactions by date (oldest to newest)
-	sort.Slice(transactions, func(i, j int) bool {
-		return transactions[i].Date.Before(transactions[j].Date)
-	})
+	for _, outcome := range recorded {
+		job.RecordRow(name, outcome.Ordinal, outcome.Status, outcome.Reason)
+	}
+	return nil
+}
 
-	// Insert transactions into the database
-	for _, transaction := range transactions {
-		if _, err := models.Create(db, &transaction); err != nil {
-			log.Println("Error inserting transaction into the database:", err)
-			continue
+// parseImportFile streams the rows of a file to emit, detecting the format when imp is nil
+func parseImportFile(file io.Reader, imp importers.Importer, emit func(importers.Row) error) (importers.Importer, error) {
+	// Detect the format from the start of the file unless the caller chose one
+	reader := bufio.NewReaderSize(file, importers.DetectSize)
+	if imp == nil {
+		head, _ := reader.Peek(importers.DetectSize)
+		detected, err := importers.Detect(head)
+		if err != nil {
+			return nil, err
 		}
-
+		imp = detected
 	}
 
-	// Generate positions after creating the transaction
-	if err := models.GeneratePositions(db, accountID); err != nil {
-		log.Println("Error inserting postions into the database:", err)
+	if err := imp.Parse(reader, emit); err != nil {
+		return imp, fmt.Errorf("parsing %s file: %v", imp.Name(), err)
 	}
+	return imp, nil
+}
+
+// rowOutcome is what importing a parsed row would do, RowImported means the row is new
+type rowOutcome struct {
+	Ordinal     int                `json:"ordinal"`
+	Status      string             `json:"status"`
+	Reason      string             `json:"reason,omitempty"`
+	Transaction models.Transaction `json:"transaction"`
 }
 
-// Helper function to extract the correct date from the date string
-func extractCorrectDate(dateStr string) string {
-	// Split the date string by " as of "
-	parts := strings.Split(dateStr, " as of ")
-	// Return the last part
-	return parts[len(parts)-1]
+// rowClassifier decides for every parsed row of a file whether it is new, a duplicate, unsupported
+// or failed without writing anything. Rows are matched to earlier imports by their fingerprint, so
+// re-importing an overlapping statement skips exactly the rows that were imported before.
+type rowClassifier struct {
+	db            *gorm.DB
+	accountID     uint
+	occurrences   map[string]int
+	legacy        int64
+	matchedLegacy map[uint]bool
 }
 
-// Helper function to parse monetary values by removing $ and ,
-func parseMonetaryValue(value string) (float64, error) {
-	cleanedValue := strings.ReplaceAll(strings.ReplaceAll(value, "$", ""), ",", "")
-	return strconv.ParseFloat(cleanedValue, 64)
+func newRowClassifier(db *gorm.DB, accountID uint) (*rowClassifier, error) {
+	legacy, err := models.CountUnfingerprintedTransactions(db, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return &rowClassifier{
+		db:            db,
+		accountID:     accountID,
+		occurrences:   make(map[string]int),
+		legacy:        legacy,
+		matchedLegacy: make(map[uint]bool),
+	}, nil
+}
+
+// classify classifies the next batch of rows of the file
+func (c *rowClassifier) classify(rows []importers.Row) ([]rowOutcome, error) {
+	outcomes := make([]rowOutcome, 0, len(rows))
+	var fingerprints []string
+	for _, row := range rows {
+		bt := row.Transaction
+		bt.AccountID = c.accountID
+		outcome := rowOutcome{Ordinal: row.Ordinal, Status: models.RowImported, Transaction: bt}
+
+		if row.Err != nil {
+			outcome.Status = models.RowFailed
+			outcome.Reason = row.Err.Error()
+		} else if !allowedActions[bt.Action] {
+			outcome.Status = models.RowSkippedUnsupported
+			outcome.Reason = fmt.Sprintf("unsupported action %q", bt.Action)
+		} else {
+			// Identical rows within the file are numbered so separate fills keep distinct fingerprints
+			content := bt.ContentHash(0)
+			c.occurrences[content]++
+			outcome.Transaction.Fingerprint = bt.ContentHash(c.occurrences[content])
+			fingerprints = append(fingerprints, outcome.Transaction.Fingerprint)
+		}
+		outcomes = append(outcomes, outcome)
+	}
+
+	existing, err := models.FindTransactionIDsByFingerprint(c.db, c.accountID, fingerprints)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range outcomes {
+		outcome := &outcomes[i]
+		if outcome.Status != models.RowImported {
+			continue
+		}
+		if id, ok := existing[outcome.Transaction.Fingerprint]; ok {
+			outcome.Status = models.RowSkippedDuplicate
+			outcome.Reason = fmt.Sprintf("matches transaction %d", id)
+			continue
+		}
+		if c.legacy == 0 {
+			continue
+		}
+
+		// Transactions stored without a fingerprint are matched on their values, each one once
+		matches, err := models.FindUnfingerprintedMatches(c.db, c.accountID, outcome.Transaction)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if !c.matchedLegacy[m.ID] {
+				c.matchedLegacy[m.ID] = true
+				outcome.Status = models.RowSkippedDuplicate
+				outcome.Reason = fmt.Sprintf("matches transaction %d", m.ID)
+				break
+			}
+		}
+	}
+	return outcomes, nil
 }
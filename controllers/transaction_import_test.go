@@ -0,0 +1,344 @@
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"stock-portfolio-api/config"
+	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupDB(t *testing.T) *gorm.DB {
+	// A file database so the import goroutine shares it with the test. Concurrent imports wait
+	// for sqlite's single writer instead of failing with "database is locked".
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000&_txlock=immediate"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&models.Account{}, &models.User{}, &models.Transaction{}, &models.Position{}, &models.StockSplit{}, &models.ImportJob{}, &models.ImportRowResult{}, &models.Session{}, &models.RefreshToken{}, &models.UserToken{}, &models.RecoveryCode{}, &models.APIKey{}, &models.AccountShare{}, &models.LoginAttempt{}, &models.AuditEntry{})
+	return db
+}
+
+// withUser puts the user ID on the request context the way VerifyJWT does
+func withUser(r *http.Request, userID uint) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), "id", float64(userID)))
+}
+
+// upload is a file posted to HandleImport
+type upload struct {
+	name, content string
+}
+
+func importRequest(t *testing.T, fields map[string]string, filename, content string) *http.Request {
+	return importUploadsRequest(t, fields, upload{filename, content})
+}
+
+func importUploadsRequest(t *testing.T, fields map[string]string, uploads ...upload) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for k, v := range fields {
+		writer.WriteField(k, v)
+	}
+	for _, u := range uploads {
+		part, err := writer.CreateFormFile("file", u.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(u.content))
+	}
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/protected/transactions/import", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestHandleImportPreview(t *testing.T) {
+	db := setupDB(t)
+	cont := controllers.InitController(db, &config.Config{})
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&user)
+	account := models.Account{UserID: user.ID, Name: "Test Account"}
+	db.Create(&account)
+
+	existing := models.Transaction{
+		Date:        time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local),
+		Action:      "Buy",
+		Symbol:      "AAPL",
+		Description: "APPLE INC",
+		Quantity:    100,
+		Price:       180,
+		Amount:      -18000,
+		AccountID:   account.ID,
+	}
+	models.Create(db, &existing)
+
+	file := `{"BrokerageTransactions": [
+		{"Date": "01/05/2024", "Action": "Buy", "Symbol": "AAPL", "Description": "APPLE INC", "Quantity": "100", "Price": "$180.00", "Fees & Comm": "", "Amount": "-$18,000.00"},
+		{"Date": "01/08/2024", "Action": "Sell to Open", "Symbol": "AAPL 02/16/2024 200.00 C", "Description": "CALL APPLE INC", "Quantity": "1", "Price": "$2.10", "Fees & Comm": "$0.66", "Amount": "$209.34"},
+		{"Date": "01/09/2024", "Action": "Journal", "Symbol": "", "Description": "JOURNAL", "Quantity": "", "Price": "", "Fees & Comm": "", "Amount": "$100.00"}
+	]}`
+
+	req := withUser(importRequest(t, map[string]string{"account_id": "1", "preview": "true"}, "schwab.json", file), user.ID)
+	rr := httptest.NewRecorder()
+	cont.HandleImport(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	var resp struct {
+		Files []struct {
+			Format string
+			Rows   []struct {
+				Ordinal int
+				Status  string
+			}
+		}
+		Summary map[string]int
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Files) != 1 || resp.Files[0].Format != "schwab-json" {
+		t.Fatalf("unexpected preview files: %+v", resp.Files)
+	}
+	expected := []string{"duplicate", "new", "unsupported"}
+	for i, row := range resp.Files[0].Rows {
+		if row.Status != expected[i] {
+			t.Errorf("row %d: got status %v want %v", row.Ordinal, row.Status, expected[i])
+		}
+	}
+	if resp.Summary["new"] != 1 {
+		t.Errorf("unexpected summary: %v", resp.Summary)
+	}
+
+	// Nothing should be written by a preview
+	var count int64
+	db.Model(&models.Transaction{}).Count(&count)
+	if count != 1 {
+		t.Errorf("preview wrote transactions: got %v want %v", count, 1)
+	}
+	db.Model(&models.ImportJob{}).Count(&count)
+	if count != 0 {
+		t.Errorf("preview created an import job: got %v want %v", count, 0)
+	}
+}
+
+// runImport posts a file to HandleImport and waits for its job to finish
+func runImport(t *testing.T, cont *controllers.Controller, db *gorm.DB, userID uint, content string) *models.ImportJob {
+	req := withUser(importRequest(t, map[string]string{"account_id": "1"}, "schwab.json", content), userID)
+	return waitForImport(t, db, startImport(t, cont, req))
+}
+
+// startImport posts the request to HandleImport and returns the ID of the job it started
+func startImport(t *testing.T, cont *controllers.Controller, req *http.Request) uint {
+	rr := httptest.NewRecorder()
+	cont.HandleImport(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	var resp struct {
+		Job struct{ ID uint }
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	return resp.Job.ID
+}
+
+// waitForImport waits for the import job to finish
+func waitForImport(t *testing.T, db *gorm.DB, jobID uint) *models.ImportJob {
+	for i := 0; i < 100; i++ {
+		job, err := models.FindImportJobByID(db, jobID)
+		if err == nil && (job.Status == models.ImportSucceeded || job.Status == models.ImportFailed) {
+			return job
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("import job %v did not finish", jobID)
+	return nil
+}
+
+func TestHandleImportDeduplication(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cfg.Import.DownloadPath = t.TempDir()
+	cont := controllers.InitController(db, cfg)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&user)
+	account := models.Account{UserID: user.ID, Name: "Test Account"}
+	db.Create(&account)
+
+	fill := `{"Date": "01/08/2024", "Action": "Sell to Open", "Symbol": "AAPL 02/16/2024 200.00 C", "Description": "CALL APPLE INC", "Quantity": "1", "Price": "$2.10", "Fees & Comm": "$0.66", "Amount": "$209.34"}`
+	buy := `{"Date": "01/05/2024", "Action": "Buy", "Symbol": "AAPL", "Description": "APPLE INC", "Quantity": "100", "Price": "$180.00", "Fees & Comm": "", "Amount": "-$18,000.00"}`
+	backfill := `{"Date": "12/01/2023", "Action": "Buy", "Symbol": "MSFT", "Description": "MICROSOFT CORP", "Quantity": "10", "Price": "$370.00", "Fees & Comm": "", "Amount": "-$3,700.00"}`
+
+	// Two separate 1-lot fills with identical values
+	job := runImport(t, cont, db, user.ID, `{"BrokerageTransactions": [`+fill+`,`+fill+`,`+buy+`]}`)
+	if job.Imported != 3 {
+		t.Errorf("first import: got %v imported want %v", job.Imported, 3)
+	}
+
+	// An overlapping statement that also reaches further back
+	job = runImport(t, cont, db, user.ID, `{"BrokerageTransactions": [`+fill+`,`+fill+`,`+buy+`,`+backfill+`]}`)
+	if job.Imported != 1 || job.SkippedDuplicate != 3 {
+		t.Errorf("second import: got %v imported and %v duplicates want 1 and 3", job.Imported, job.SkippedDuplicate)
+	}
+
+	var count int64
+	db.Model(&models.Transaction{}).Where("account_id = ?", account.ID).Count(&count)
+	if count != 4 {
+		t.Errorf("got %v transactions want %v", count, 4)
+	}
+
+	// Importing the same statement again changes nothing
+	job = runImport(t, cont, db, user.ID, `{"BrokerageTransactions": [`+fill+`,`+fill+`,`+buy+`,`+backfill+`]}`)
+	if job.Imported != 0 || job.SkippedDuplicate != 4 {
+		t.Errorf("third import: got %v imported and %v duplicates want 0 and 4", job.Imported, job.SkippedDuplicate)
+	}
+}
+
+func TestHandleImportUploads(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cfg.Import.DownloadPath = t.TempDir()
+	cont := controllers.InitController(db, cfg)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&user)
+	first := models.Account{UserID: user.ID, Name: "First Account"}
+	db.Create(&first)
+	second := models.Account{UserID: user.ID, Name: "Second Account"}
+	db.Create(&second)
+
+	statement := func(symbol string) string {
+		return `{"BrokerageTransactions": [{"Date": "01/05/2024", "Action": "Buy", "Symbol": "` + symbol + `", "Description": "` + symbol + `", "Quantity": "10", "Price": "$100.00", "Fees & Comm": "", "Amount": "-$1,000.00"}]}`
+	}
+
+	// Files with the same name from different folders are both imported
+	req := withUser(importUploadsRequest(t, map[string]string{"account_id": fmt.Sprint(first.ID)},
+		upload{"2023/statement.json", statement("AAPL")}, upload{"2024/statement.json", statement("MSFT")}), user.ID)
+	job := waitForImport(t, db, startImport(t, cont, req))
+	if job.Imported != 2 || job.SkippedDuplicate != 0 {
+		t.Errorf("same names: got %v imported and %v duplicates want 2 and 0", job.Imported, job.SkippedDuplicate)
+	}
+
+	// Jobs running at the same time only import their own files
+	firstJob := startImport(t, cont, withUser(importRequest(t, map[string]string{"account_id": fmt.Sprint(first.ID)}, "statement.json", statement("NVDA")), user.ID))
+	secondJob := startImport(t, cont, withUser(importRequest(t, map[string]string{"account_id": fmt.Sprint(second.ID)}, "statement.json", statement("TSLA")), user.ID))
+	for _, tc := range []struct {
+		jobID  uint
+		symbol string
+	}{{firstJob, "NVDA"}, {secondJob, "TSLA"}} {
+		job := waitForImport(t, db, tc.jobID)
+		var symbols []string
+		db.Model(&models.Transaction{}).Where("import_job_id = ?", job.ID).Pluck("symbol", &symbols)
+		if job.Imported != 1 || len(symbols) != 1 || symbols[0] != tc.symbol {
+			t.Errorf("job %v: got %v imported with symbols %v want only %v: %v", job.ID, job.Imported, symbols, tc.symbol, job.Error)
+		}
+	}
+}
+
+func TestHandleImportIBKRFlex(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cfg.Import.DownloadPath = t.TempDir()
+	cont := controllers.InitController(db, cfg)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&user)
+	account := models.Account{UserID: user.ID, Name: "Test Account"}
+	db.Create(&account)
+
+	flex := `<?xml version="1.0" encoding="UTF-8"?>
+<FlexQueryResponse queryName="Activity" type="AF">
+<FlexStatements count="1">
+<FlexStatement accountId="U1234567" fromDate="20240101" toDate="20240229">
+<Trades>
+<Trade assetCategory="STK" symbol="AAPL" description="APPLE INC" tradeDate="20240105" quantity="100" tradePrice="180" ibCommission="-1" netCash="-18001" buySell="BUY" openCloseIndicator="O" levelOfDetail="EXECUTION" />
+</Trades>
+<CashTransactions>
+<CashTransaction type="Dividends" symbol="AAPL" description="AAPL CASH DIVIDEND USD 0.24 PER SHARE" dateTime="20240215;202000" amount="24" />
+<CashTransaction type="Deposits/Withdrawals" description="CASH RECEIPTS" dateTime="20240102" amount="20000" />
+</CashTransactions>
+</FlexStatement>
+</FlexStatements>
+</FlexQueryResponse>
+`
+	job := runImport(t, cont, db, user.ID, flex)
+	if job.Status != models.ImportSucceeded || job.Imported != 2 || job.SkippedUnsupported != 1 {
+		t.Errorf("got status %v with %v imported and %v unsupported want %v with 2 and 1", job.Status, job.Imported, job.SkippedUnsupported, models.ImportSucceeded)
+	}
+
+	var dividend models.Transaction
+	if err := db.Where("account_id = ? AND action = ?", account.ID, "Cash Dividend").First(&dividend).Error; err != nil {
+		t.Fatalf("dividend was not stored: %v", err)
+	}
+	if dividend.Symbol != "AAPL" || dividend.Amount != 24 {
+		t.Errorf("got dividend %v %v want AAPL 24", dividend.Symbol, dividend.Amount)
+	}
+
+	// The dividend doesn't change the position opened by the trade
+	positions, err := models.FetchPositionsByAccount(db, account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 1 || positions[0].Quantity != 100 {
+		t.Errorf("got positions %+v want 100 AAPL", positions)
+	}
+}
+
+func TestHandleImportLargeStatement(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cfg.Import.DownloadPath = t.TempDir()
+	cont := controllers.InitController(db, cfg)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&user)
+	account := models.Account{UserID: user.ID, Name: "Test Account"}
+	db.Create(&account)
+
+	// Enough rows to span several insert batches
+	rows := make([]string, 2500)
+	for i := range rows {
+		rows[i] = fmt.Sprintf(`{"Date": "01/05/2024", "Action": "Buy", "Symbol": "AAPL", "Description": "APPLE INC", "Quantity": "1", "Price": "$%d.00", "Fees & Comm": "", "Amount": "-$%d.00"}`, 100+i, 100+i)
+	}
+	content := `{"BrokerageTransactions": [` + strings.Join(rows, ",") + `]}`
+
+	job := runImport(t, cont, db, user.ID, content)
+	if job.Status != models.ImportSucceeded || job.Imported != len(rows) {
+		t.Errorf("got status %v with %v imported want %v with %v", job.Status, job.Imported, models.ImportSucceeded, len(rows))
+	}
+
+	var count int64
+	db.Model(&models.Transaction{}).Where("account_id = ? AND import_job_id = ?", account.ID, job.ID).Count(&count)
+	if count != int64(len(rows)) {
+		t.Errorf("got %v transactions want %v", count, len(rows))
+	}
+
+	// A statement with a failing batch leaves nothing behind
+	job = runImport(t, cont, db, user.ID, `{"BrokerageTransactions": [`+strings.Join(rows[:1500], ",")+`,`)
+	if job.Status != models.ImportFailed || job.Imported != 0 {
+		t.Errorf("got status %v with %v imported want %v with 0", job.Status, job.Imported, models.ImportFailed)
+	}
+	db.Model(&models.Transaction{}).Where("import_job_id = ?", job.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("got %v transactions from the failed import want 0", count)
+	}
+}
@@ -0,0 +1,74 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"stock-portfolio-api/config"
+	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHandleUpdateTransaction(t *testing.T) {
+	db := setupDB(t)
+	cont := controllers.InitController(db, &config.Config{})
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&user)
+	account := models.Account{UserID: user.ID, Name: "Test Account"}
+	db.Create(&account)
+	other := models.User{Email: "other@example.com", PasswordHash: "hashedpassword"}
+	db.Create(&other)
+
+	date := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	db.Create(&[]models.Transaction{
+		{AccountID: account.ID, Date: date, Action: "Buy", Symbol: "AAPL", Quantity: 100, Price: 180, Amount: -18000},
+		{AccountID: account.ID, Date: date, Action: "Sell", Symbol: "APPL", Quantity: 10, Price: 190, Amount: 1900},
+	})
+	if err := models.GeneratePositions(db, account.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(userID uint, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/protected/transactions/2", strings.NewReader(body))
+		req = mux.SetURLVars(withUser(req, userID), map[string]string{"id": "2"})
+		rr := httptest.NewRecorder()
+		cont.HandleUpdateTransaction(rr, req)
+		return rr
+	}
+
+	// Another user can't change the transaction
+	if rr := update(other.ID, `{"Symbol": "AAPL"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := update(user.ID, `{"Price": "abc"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	// Fix the mistyped symbol and quantity of the sale
+	rr := update(user.ID, `{"Symbol": "AAPL", "Quantity": "20", "Amount": "$3,800.00"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	transaction, err := models.FindTransactionByID(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.Symbol != "AAPL" || transaction.Quantity != -20 || transaction.Amount != 3800 || transaction.Price != 190 {
+		t.Errorf("got %+v want AAPL with quantity -20, amount 3800 and price 190", transaction)
+	}
+
+	positions, err := models.FetchPositionsByAccount(db, account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 1 || positions[0].Quantity != 80 {
+		t.Errorf("got positions %+v want a single AAPL position of 80", positions)
+	}
+}
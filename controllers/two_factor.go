@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/bcrypt"
+
+	"stock-portfolio-api/models"
+)
+
+const (
+	// totpIssuer is the name authenticator apps show next to the code
+	totpIssuer = "Stock Portfolio"
+	// preAuthTokenTTL is how long the user has to enter the code after the password
+	preAuthTokenTTL = 5 * time.Minute
+	// preAuthPurpose marks a token that only proves the password, it has no session so
+	// VerifyJWT never accepts it
+	preAuthPurpose = "2fa"
+)
+
+// TwoFactorCodeRequest confirms a TOTP code
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// DisableTwoFactorRequest needs the password and a current code or recovery code
+type DisableTwoFactorRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TwoFactorLoginRequest finishes a login with the pre-auth token from HandleLogin
+type TwoFactorLoginRequest struct {
+	PreAuthToken string `json:"pre_auth_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// HandleEnrollTwoFactor handles starting TOTP enrollment, the secret has to be confirmed with
+// HandleVerifyTwoFactor before it is required on login
+func (c Controller) HandleEnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	secret, err := models.StartTOTPEnrollment(c.db, u)
+	if errors.Is(err, models.ErrTOTPAlreadyEnabled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           secret,
+		"provisioning_uri": models.TOTPProvisioningURI(totpIssuer, u.Email, secret),
+	})
+}
+
+// HandleVerifyTwoFactor handles confirming enrollment with a code from the authenticator, it
+// enables two-factor and returns the recovery codes
+func (c Controller) HandleVerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorCodeRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := models.EnableTOTP(c.db, u, req.Code)
+	switch {
+	case errors.Is(err, models.ErrTOTPAlreadyEnabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, models.ErrTOTPNotEnrolled), errors.Is(err, models.ErrInvalidTOTPCode):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// HandleDisableTwoFactor handles turning two-factor off
+func (c Controller) HandleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req DisableTwoFactorRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+	if !u.TOTPEnabled() {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+
+	// A stolen access token alone must not be enough to remove the second factor
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(req.Password)) != nil {
+		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+	if err := models.VerifySecondFactor(c.db, u, req.Code, req.RecoveryCode); err != nil {
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	if err := models.DisableTOTP(c.db, u); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleTwoFactorLogin handles the second login step, a TOTP code or recovery code with the
+// pre-auth token is exchanged for the usual access and refresh tokens
+func (c Controller) HandleTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorLoginRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.PreAuthToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		http.Error(w, "pre_auth_token and code or recovery_code is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := c.parsePreAuthToken(req.PreAuthToken)
+	if err != nil {
+		http.Error(w, "Invalid pre-auth token", http.StatusUnauthorized)
+		return
+	}
+	user, err := models.FindUserByID(c.db, userID)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	// Codes count as login attempts so the six digits can't be guessed
+	ip := c.clientIP(r)
+	if !c.allowLogin(w, user.Email, ip) {
+		return
+	}
+	if err := models.VerifySecondFactor(c.db, user, req.Code, req.RecoveryCode); err != nil {
+		c.recordLogin(user.Email, ip, user, models.LoginBadCode)
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	session, refreshToken, err := models.CreateSession(c.db, user.ID, c.cfg.RefreshTokenTTL())
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	method := "totp"
+	if req.RecoveryCode != "" {
+		method = "recovery_code"
+	}
+	c.recordLogin(user.Email, ip, user, models.LoginSucceeded)
+	c.audit(r, user, models.AuditLogin, 0, session.ID, nil, map[string]string{"method": method})
+	c.writeTokens(w, user, session, refreshToken)
+}
+
+// writePreAuthToken responds with a token that can only be used to finish the login with a code
+func (c Controller) writePreAuthToken(w http.ResponseWriter, user *models.User) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":      user.ID,
+		"purpose": preAuthPurpose,
+		"exp":     time.Now().Add(preAuthTokenTTL).Unix(),
+	})
+	tokenString, err := token.SignedString([]byte(c.cfg.JWT.Secret))
+	if err != nil {
+		http.Error(w, "Failed to create JWT token", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"two_factor_required": true,
+		"pre_auth_token":      tokenString,
+		"expires_in":          int(preAuthTokenTTL.Seconds()),
+	})
+}
+
+// parsePreAuthToken returns the user ID of a valid pre-auth token
+func (c Controller) parsePreAuthToken(tokenString string) (uint, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(c.cfg.JWT.Secret), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != preAuthPurpose {
+		return 0, fmt.Errorf("not a pre-auth token")
+	}
+	userID, ok := claims["id"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("not a pre-auth token")
+	}
+	return uint(userID), nil
+}
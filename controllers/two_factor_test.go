@@ -0,0 +1,107 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"stock-portfolio-api/config"
+	"stock-portfolio-api/controllers"
+	"stock-portfolio-api/models"
+)
+
+func TestTwoFactorLogin(t *testing.T) {
+	db := setupDB(t)
+	cfg := &config.Config{}
+	cfg.JWT.Secret = "secret"
+	cont := controllers.InitController(db, cfg)
+	cont.SetMailer(&recordingMailer{})
+
+	call := func(handler http.Handler, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	protected := func(handler http.HandlerFunc) http.Handler {
+		return cont.VerifyJWT(handler)
+	}
+	decode := func(rr *httptest.ResponseRecorder) map[string]interface{} {
+		var body map[string]interface{}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatalf("decode %q: %v", rr.Body.String(), err)
+		}
+		return body
+	}
+	credentials := `{"Email": "test@example.com", "Password": "password123"}`
+
+	call(http.HandlerFunc(cont.HandleSignup), "", credentials)
+	rr := call(http.HandlerFunc(cont.HandleLogin), "", credentials)
+	token, _ := decode(rr)["token"].(string)
+	if token == "" {
+		t.Fatalf("login without two-factor: got %q want a token", rr.Body.String())
+	}
+
+	rr = call(protected(cont.HandleEnrollTwoFactor), token, "")
+	enrollment := decode(rr)
+	secret, _ := enrollment["secret"].(string)
+	uri, _ := enrollment["provisioning_uri"].(string)
+	if secret == "" || !strings.HasPrefix(uri, "otpauth://totp/") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("enroll: got %v want a secret and provisioning uri", enrollment)
+	}
+
+	if rr = call(protected(cont.HandleVerifyTwoFactor), token, `{"code": "000000"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("verify with a wrong code: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	code, _ := models.TOTPCode(secret, time.Now())
+	rr = call(protected(cont.HandleVerifyTwoFactor), token, `{"code": "`+code+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("verify: got status %v want %v", rr.Code, http.StatusOK)
+	}
+	recovery, _ := decode(rr)["recovery_codes"].([]interface{})
+	if len(recovery) != 10 {
+		t.Fatalf("got %v recovery codes want 10", len(recovery))
+	}
+
+	// The password now only gives a pre-auth token which the API doesn't accept
+	rr = call(http.HandlerFunc(cont.HandleLogin), "", credentials)
+	body := decode(rr)
+	preAuth, _ := body["pre_auth_token"].(string)
+	if body["two_factor_required"] != true || preAuth == "" || body["token"] != nil {
+		t.Fatalf("login with two-factor: got %v want only a pre-auth token", body)
+	}
+	if rr = call(protected(cont.HandleEnrollTwoFactor), preAuth, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("pre-auth token on a protected route: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr = call(http.HandlerFunc(cont.HandleTwoFactorLogin), "", `{"pre_auth_token": "`+token+`", "code": "123456"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("access token as pre-auth token: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr = call(http.HandlerFunc(cont.HandleTwoFactorLogin), "", `{"pre_auth_token": "`+preAuth+`", "code": "`+code+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	rr = call(http.HandlerFunc(cont.HandleTwoFactorLogin), "", `{"pre_auth_token": "`+preAuth+`", "recovery_code": "`+recovery[0].(string)+`"}`)
+	token, _ = decode(rr)["token"].(string)
+	if rr.Code != http.StatusOK || token == "" {
+		t.Fatalf("login with a recovery code: got status %v want a token", rr.Code)
+	}
+	if rr = call(http.HandlerFunc(cont.HandleTwoFactorLogin), "", `{"pre_auth_token": "`+preAuth+`", "recovery_code": "`+recovery[0].(string)+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("reused recovery code: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	if rr = call(protected(cont.HandleDisableTwoFactor), token, `{"password": "wrong", "recovery_code": "`+recovery[1].(string)+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("disable with a wrong password: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr = call(protected(cont.HandleDisableTwoFactor), token, `{"password": "password123", "recovery_code": "`+recovery[1].(string)+`"}`); rr.Code != http.StatusNoContent {
+		t.Errorf("disable: got status %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if _, ok := decode(call(http.HandlerFunc(cont.HandleLogin), "", credentials))["token"].(string); !ok {
+		t.Error("login after disabling two-factor: want a token")
+	}
+}
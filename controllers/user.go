@@ -47,6 +47,11 @@ func (c Controller) HandleSignup(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to create user", http.StatusConflict)
 		return
 	}
+
+	// The account works right away, the mail only confirms the address
+	if err := c.sendVerificationEmail(newUser); err != nil {
+		log.Println("Send verification email: ", err)
+	}
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -60,23 +65,112 @@ func (c Controller) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Failed logins are delayed before any password is checked
+	ip := c.clientIP(r)
+	if !c.allowLogin(w, req.Email, ip) {
+		return
+	}
+
 	// login
 	user, err := models.FindUserByEmail(c.db, req.Email)
 	if err != nil {
+		c.recordLogin(req.Email, ip, nil, models.LoginUnknownEmail)
 		http.Error(w, "Invalid login", http.StatusNotFound)
 		return
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
 	if err != nil {
+		c.recordLogin(req.Email, ip, user, models.LoginBadPassword)
 		http.Error(w, "Invalid login", http.StatusNotFound)
 		return
 	}
 
+	// With two-factor enabled the password only earns a pre-auth token for /login/2fa
+	if user.TOTPEnabled() {
+		c.writePreAuthToken(w, user)
+		return
+	}
+
+	session, refreshToken, err := models.CreateSession(c.db, user.ID, c.cfg.RefreshTokenTTL())
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	c.recordLogin(req.Email, ip, user, models.LoginSucceeded)
+	c.audit(r, user, models.AuditLogin, 0, session.ID, nil, map[string]string{"method": "password"})
+	c.writeTokens(w, user, session, refreshToken)
+}
+
+// RefreshRequest exchanges a refresh token for new tokens
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// HandleRefresh handles exchanging a refresh token for a new access token and refresh token,
+// the refresh token can only be used once
+func (c Controller) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	session, refreshToken, err := models.RotateRefreshToken(c.db, req.RefreshToken)
+	if err != nil {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := models.FindUserByID(c.db, session.UserID)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	c.writeTokens(w, user, session, refreshToken)
+}
+
+// LogoutRequest optionally ends every session of the user instead of only the current one
+type LogoutRequest struct {
+	All bool `json:"all"`
+}
+
+// HandleLogout handles revoking the session of the access token, its access and refresh tokens
+// stop working immediately
+func (c Controller) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	var req LogoutRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	u, err := userFromRequestContext(r, c.db)
+	if err != nil {
+		http.Error(w, "Unable to find user", http.StatusUnauthorized)
+		return
+	}
+
+	if req.All {
+		err = models.RevokeUserSessions(c.db, u.ID)
+	} else {
+		sessionID, _ := r.Context().Value("sid").(float64)
+		err = models.RevokeSession(c.db, uint(sessionID))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTokens responds with a short-lived access token for the session and its refresh token
+func (c Controller) writeTokens(w http.ResponseWriter, user *models.User, session *models.Session, refreshToken string) {
 	// create a JWT token"
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":    user.ID,
 		"email": user.Email,
-		"exp":   time.Now().Add(time.Hour * 24).Unix(),
+		"sid":   session.ID,
+		"exp":   time.Now().Add(c.cfg.AccessTokenTTL()).Unix(),
 	})
 
 	// sign the JWT token with a secret key
@@ -87,8 +181,10 @@ func (c Controller) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// send the JWT token as a response
-	json.NewEncoder(w).Encode(map[string]string{
-		"token": tokenString,
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    int(c.cfg.AccessTokenTTL().Seconds()),
 	})
 }
 
@@ -0,0 +1,204 @@
+package importers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"stock-portfolio-api/models"
+)
+
+// ColumnMapping maps the header names of a CSV file onto Transaction fields
+type ColumnMapping struct {
+	Date        string `json:"date"`
+	Action      string `json:"action"`
+	Symbol      string `json:"symbol"`
+	Description string `json:"description"`
+	Quantity    string `json:"quantity"`
+	Price       string `json:"price"`
+	Fees        string `json:"fees"`
+	Amount      string `json:"amount"`
+	// DateFormat is a Go time layout, defaults to 01/02/2006
+	DateFormat string `json:"date_format"`
+	// Actions translates the file's action text into the project's actions, e.g. "BOUGHT": "Buy"
+	Actions map[string]string `json:"actions"`
+}
+
+// DefaultColumnMapping matches a CSV whose headers are the Transaction field names
+var DefaultColumnMapping = ColumnMapping{
+	Date:        "Date",
+	Action:      "Action",
+	Symbol:      "Symbol",
+	Description: "Description",
+	Quantity:    "Quantity",
+	Price:       "Price",
+	Fees:        "Fees",
+	Amount:      "Amount",
+}
+
+// GenericCSV imports any CSV file through a column mapping
+type GenericCSV struct {
+	Mapping ColumnMapping
+}
+
+func init() {
+	Register(NewGenericCSV(DefaultColumnMapping), 100)
+}
+
+// NewGenericCSV creates a generic CSV importer, unset columns fall back to the default mapping
+func NewGenericCSV(mapping ColumnMapping) *GenericCSV {
+	fallback := func(value *string, def string) {
+		if *value == "" {
+			*value = def
+		}
+	}
+	fallback(&mapping.Date, DefaultColumnMapping.Date)
+	fallback(&mapping.Action, DefaultColumnMapping.Action)
+	fallback(&mapping.Symbol, DefaultColumnMapping.Symbol)
+	fallback(&mapping.Description, DefaultColumnMapping.Description)
+	fallback(&mapping.Quantity, DefaultColumnMapping.Quantity)
+	fallback(&mapping.Price, DefaultColumnMapping.Price)
+	fallback(&mapping.Fees, DefaultColumnMapping.Fees)
+	fallback(&mapping.Amount, DefaultColumnMapping.Amount)
+	fallback(&mapping.DateFormat, "01/02/2006")
+	return &GenericCSV{Mapping: mapping}
+}
+
+func (g *GenericCSV) Name() string {
+	return "csv"
+}
+
+func (g *GenericCSV) Detect(head []byte) bool {
+	_, ok := findHeaderLine(head, g.Mapping.Date, g.Mapping.Action, g.Mapping.Symbol)
+	return ok
+}
+
+func (g *GenericCSV) Parse(r io.Reader, emit func(Row) error) error {
+	m := g.Mapping
+	ordinal := 0
+	return readCSV(r, func(record []string, columns csvColumns) error {
+		ordinal++
+		row := Row{Ordinal: ordinal}
+		date, err := parseDate(columns.get(record, m.Date), m.DateFormat)
+		if err != nil {
+			row.Err = fmt.Errorf("invalid date %q", columns.get(record, m.Date))
+			return emit(row)
+		}
+		quantity, price, fees, amount, err := parseValues(columns.get(record, m.Quantity), columns.get(record, m.Price), columns.get(record, m.Fees), columns.get(record, m.Amount))
+		if err != nil {
+			row.Err = err
+			return emit(row)
+		}
+
+		action := columns.get(record, m.Action)
+		if mapped, ok := m.Actions[action]; ok {
+			action = mapped
+		}
+
+		row.Transaction = models.Transaction{
+			Date:        date,
+			Action:      action,
+			Symbol:      columns.get(record, m.Symbol),
+			Description: columns.get(record, m.Description),
+			Quantity:    quantity,
+			Price:       price,
+			Fees:        fees,
+			Amount:      amount,
+		}
+		return emit(row)
+	}, m.Date, m.Action, m.Symbol)
+}
+
+// csvColumns is the index of each header name, matched case-insensitively
+type csvColumns map[string]int
+
+func (c csvColumns) get(record []string, name string) string {
+	i, ok := c[columnName(name)]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// readCSV skips any preamble before the header row that contains all the required
+// columns and streams each data row that follows it to onRecord
+func readCSV(r io.Reader, onRecord func(record []string, columns csvColumns) error, required ...string) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	var columns csvColumns
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if columns == nil {
+			if hasColumns(record, required...) {
+				columns = csvColumns{}
+				for i, name := range record {
+					columns[columnName(name)] = i
+				}
+			}
+			continue
+		}
+		if isBlankRecord(record) {
+			continue
+		}
+		if err := onRecord(record, columns); err != nil {
+			return err
+		}
+	}
+	if columns == nil {
+		return fmt.Errorf("missing header row with columns %s", strings.Join(required, ", "))
+	}
+	return nil
+}
+
+// findHeaderLine returns the first line of head that is a CSV header containing the required columns
+func findHeaderLine(head []byte, required ...string) ([]string, bool) {
+	for _, line := range strings.Split(string(head), "\n") {
+		reader := csv.NewReader(strings.NewReader(line))
+		reader.LazyQuotes = true
+		record, err := reader.Read()
+		if err != nil {
+			continue
+		}
+		if hasColumns(record, required...) {
+			return record, true
+		}
+	}
+	return nil, false
+}
+
+func hasColumns(record []string, required ...string) bool {
+	present := map[string]bool{}
+	for _, name := range record {
+		present[columnName(name)] = true
+	}
+	for _, name := range required {
+		if !present[columnName(name)] {
+			return false
+		}
+	}
+	return true
+}
+
+// columnName normalizes a header name for matching
+func columnName(name string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+}
+
+func isBlankRecord(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
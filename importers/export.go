@@ -0,0 +1,86 @@
+package importers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"stock-portfolio-api/models"
+)
+
+// Exports use the Schwab layout so they can be imported again with schwab-csv or schwab-json
+
+// exportRow converts a stored transaction back into a Schwab row. Sell quantities are stored
+// negative once positions are generated, the export gives them back as Schwab lists them.
+func exportRow(t models.Transaction) schwabRow {
+	quantity := t.Quantity
+	if strings.Contains(strings.ToLower(t.Action), "sell") {
+		quantity = math.Abs(quantity)
+	}
+	return schwabRow{
+		Date:        t.Date.Format("01/02/2006"),
+		Action:      t.Action,
+		Symbol:      t.Symbol,
+		Description: t.Description,
+		Quantity:    formatNumber(quantity),
+		Price:       formatMoney(t.Price),
+		FeesComm:    formatMoney(t.Fees),
+		Amount:      formatMoney(t.Amount),
+	}
+}
+
+// WriteSchwabCSV writes the transactions as a Schwab CSV export
+func WriteSchwabCSV(w io.Writer, transactions []models.Transaction) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"Date", "Action", "Symbol", "Description", "Quantity", "Price", "Fees & Comm", "Amount"}); err != nil {
+		return err
+	}
+	for _, t := range transactions {
+		bt := exportRow(t)
+		if err := writer.Write([]string{bt.Date, bt.Action, bt.Symbol, bt.Description, bt.Quantity, bt.Price, bt.FeesComm, bt.Amount}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteSchwabJSON writes the transactions as a Schwab JSON export
+func WriteSchwabJSON(w io.Writer, transactions []models.Transaction) error {
+	rows := make([]schwabRow, len(transactions))
+	for i, t := range transactions {
+		rows[i] = exportRow(t)
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"BrokerageTransactions": rows,
+	})
+}
+
+// formatNumber formats a value without losing precision, zero is left empty like Schwab does
+func formatNumber(value float64) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatMoney formats a value as "$1234.56" or "-$1234.56", keeping extra decimals when the
+// value has them so it reads back unchanged
+func formatMoney(value float64) string {
+	if value == 0 {
+		return ""
+	}
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	formatted := strconv.FormatFloat(value, 'f', 2, 64)
+	if parsed, _ := strconv.ParseFloat(formatted, 64); parsed != value {
+		formatted = strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return sign + "$" + formatted
+}
@@ -0,0 +1,128 @@
+package importers
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"stock-portfolio-api/models"
+)
+
+// FidelityCSV imports the Fidelity "Accounts History" CSV export
+type FidelityCSV struct{}
+
+func init() {
+	Register(FidelityCSV{}, 20)
+}
+
+var fidelityCSVColumns = []string{"Run Date", "Action", "Symbol"}
+
+// fidelityOptionSymbol matches Fidelity option symbols such as "-AAPL240216C200"
+var fidelityOptionSymbol = regexp.MustCompile(`^-([A-Z0-9.]+)(\d{6})([CP])([\d.]+)$`)
+
+// fidelityActions translates the start of Fidelity's action text into the project's actions,
+// the more specific prefixes are listed first
+var fidelityActions = []struct {
+	prefix string
+	action string
+}{
+	{"YOU SOLD OPENING TRANSACTION", "Sell to Open"},
+	{"YOU SOLD CLOSING TRANSACTION", "Sell to Close"},
+	{"YOU BOUGHT OPENING TRANSACTION", "Buy to Open"},
+	{"YOU BOUGHT CLOSING TRANSACTION", "Buy to Close"},
+	{"YOU SOLD SHORT", "Sell Short"},
+	{"SHORT SALE", "Sell Short"},
+	{"YOU BOUGHT", "Buy"},
+	{"YOU SOLD", "Sell"},
+	{"REINVESTMENT", "Reinvest Shares"},
+	{"DIVIDEND RECEIVED", "Cash Dividend"},
+	{"EXPIRED", "Expired"},
+	{"ASSIGNED", "Assigned"},
+	{"EXERCISED", "Exchange or Exercise"},
+	{"REVERSE SPLIT", "Reverse Split"},
+	{"DISTRIBUTION", "Stock Split"},
+}
+
+func (FidelityCSV) Name() string {
+	return "fidelity-csv"
+}
+
+func (FidelityCSV) Detect(head []byte) bool {
+	_, ok := findHeaderLine(head, fidelityCSVColumns...)
+	return ok
+}
+
+func (FidelityCSV) Parse(r io.Reader, emit func(Row) error) error {
+	ordinal := 0
+	return readCSV(r, func(record []string, columns csvColumns) error {
+		// The export ends with disclaimer lines that have no action
+		rawAction := columns.get(record, "Action")
+		if rawAction == "" {
+			return nil
+		}
+
+		ordinal++
+		row := Row{Ordinal: ordinal}
+		date, err := parseDate(columns.get(record, "Run Date"), "01/02/2006")
+		if err != nil {
+			row.Err = fmt.Errorf("invalid date %q", columns.get(record, "Run Date"))
+			return emit(row)
+		}
+
+		quantity, price, commission, amount, err := parseValues(columns.get(record, "Quantity"), columns.get(record, "Price ($)"), columns.get(record, "Commission ($)"), columns.get(record, "Amount ($)"))
+		if err != nil {
+			row.Err = err
+			return emit(row)
+		}
+		fees, err := parseMonetaryValue(columns.get(record, "Fees ($)"))
+		if err != nil {
+			row.Err = fmt.Errorf("invalid fees %q", columns.get(record, "Fees ($)"))
+			return emit(row)
+		}
+
+		row.Transaction = models.Transaction{
+			Date:        date,
+			Action:      fidelityAction(rawAction),
+			Symbol:      fidelitySymbol(columns.get(record, "Symbol")),
+			Description: columns.get(record, "Description"),
+			Quantity:    quantity,
+			Price:       price,
+			Fees:        commission + fees,
+			Amount:      amount,
+		}
+		return emit(row)
+	}, fidelityCSVColumns...)
+}
+
+// fidelityAction maps Fidelity's descriptive action onto the project's actions, unknown
+// actions are kept as-is so they are reported as unsupported
+func fidelityAction(action string) string {
+	upper := strings.ToUpper(strings.TrimSpace(action))
+	for _, a := range fidelityActions {
+		if strings.HasPrefix(upper, a.prefix) {
+			return a.action
+		}
+	}
+	return action
+}
+
+// fidelitySymbol converts Fidelity option symbols into the "UNDERLYING MM/DD/YYYY STRIKE C/P" convention
+func fidelitySymbol(symbol string) string {
+	symbol = strings.TrimSpace(symbol)
+	m := fidelityOptionSymbol.FindStringSubmatch(symbol)
+	if m == nil {
+		return symbol
+	}
+	expiration, err := time.Parse("060102", m[2])
+	if err != nil {
+		return symbol
+	}
+	strike, err := strconv.ParseFloat(m[4], 64)
+	if err != nil {
+		return symbol
+	}
+	return models.OptionSymbol{Underlying: m[1], Expiration: expiration, Strike: strike, Type: m[3]}.String()
+}
@@ -0,0 +1,279 @@
+package importers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"stock-portfolio-api/models"
+)
+
+// IBKRFlex imports Interactive Brokers Flex Query XML reports. Trades, CashTransactions and
+// CorporateActions sections are read, anything else in the report is ignored.
+type IBKRFlex struct{}
+
+func init() {
+	Register(IBKRFlex{}, 10)
+}
+
+// ibkrRecord holds the attributes used from Trade, CashTransaction and CorporateAction elements
+type ibkrRecord struct {
+	AssetCategory      string `xml:"assetCategory,attr"`
+	Symbol             string `xml:"symbol,attr"`
+	Description        string `xml:"description,attr"`
+	UnderlyingSymbol   string `xml:"underlyingSymbol,attr"`
+	PutCall            string `xml:"putCall,attr"`
+	Strike             string `xml:"strike,attr"`
+	Expiry             string `xml:"expiry,attr"`
+	TradeDate          string `xml:"tradeDate,attr"`
+	DateTime           string `xml:"dateTime,attr"`
+	ReportDate         string `xml:"reportDate,attr"`
+	Quantity           string `xml:"quantity,attr"`
+	TradePrice         string `xml:"tradePrice,attr"`
+	IBCommission       string `xml:"ibCommission,attr"`
+	Taxes              string `xml:"taxes,attr"`
+	NetCash            string `xml:"netCash,attr"`
+	Amount             string `xml:"amount,attr"`
+	BuySell            string `xml:"buySell,attr"`
+	OpenCloseIndicator string `xml:"openCloseIndicator,attr"`
+	Notes              string `xml:"notes,attr"`
+	Type               string `xml:"type,attr"`
+	LevelOfDetail      string `xml:"levelOfDetail,attr"`
+}
+
+func (IBKRFlex) Name() string {
+	return "ibkr-flex"
+}
+
+func (IBKRFlex) Detect(head []byte) bool {
+	return bytes.Contains(head, []byte("<FlexQueryResponse"))
+}
+
+func (IBKRFlex) Parse(r io.Reader, emit func(Row) error) error {
+	decoder := xml.NewDecoder(r)
+
+	ordinal := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var convert func(ibkrRecord) ([]models.Transaction, error)
+		switch start.Name.Local {
+		case "Trade":
+			convert = ibkrTrade
+		case "CashTransaction":
+			convert = ibkrCashTransaction
+		case "CorporateAction":
+			convert = ibkrCorporateAction
+		default:
+			continue
+		}
+
+		var record ibkrRecord
+		if err := decoder.DecodeElement(&record, &start); err != nil {
+			return err
+		}
+		// Order and summary levels repeat the executions they are made of
+		if record.LevelOfDetail != "" && record.LevelOfDetail != "EXECUTION" && record.LevelOfDetail != "DETAIL" {
+			continue
+		}
+
+		transactions, err := convert(record)
+		if err != nil {
+			ordinal++
+			if err := emit(Row{Ordinal: ordinal, Err: err}); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, t := range transactions {
+			ordinal++
+			if err := emit(Row{Ordinal: ordinal, Transaction: t}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ibkrTrade maps a Trade onto Buy/Sell for stock and the open/close actions for options
+func ibkrTrade(rec ibkrRecord) ([]models.Transaction, error) {
+	date, err := ibkrDate(rec.TradeDate, rec.DateTime)
+	if err != nil {
+		return nil, err
+	}
+	quantity, price, commission, amount, err := parseValues(rec.Quantity, rec.TradePrice, rec.IBCommission, rec.NetCash)
+	if err != nil {
+		return nil, err
+	}
+	taxes, err := parseMonetaryValue(rec.Taxes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid taxes %q", rec.Taxes)
+	}
+
+	opening := strings.HasPrefix(rec.OpenCloseIndicator, "O")
+	buy := rec.BuySell == "BUY" || (rec.BuySell == "" && quantity > 0)
+
+	var action string
+	if rec.AssetCategory == "OPT" {
+		switch {
+		case ibkrHasNote(rec.Notes, "Ep"):
+			action = "Expired"
+		case ibkrHasNote(rec.Notes, "A"):
+			action = "Assigned"
+		case ibkrHasNote(rec.Notes, "Ex"):
+			action = "Exchange or Exercise"
+		case buy && opening:
+			action = "Buy to Open"
+		case buy:
+			action = "Buy to Close"
+		case opening:
+			action = "Sell to Open"
+		default:
+			action = "Sell to Close"
+		}
+	} else {
+		switch {
+		case buy:
+			action = "Buy"
+		case opening:
+			action = "Sell Short"
+		default:
+			action = "Sell"
+		}
+	}
+
+	return []models.Transaction{{
+		Date:        date,
+		Action:      action,
+		Symbol:      ibkrSymbol(rec),
+		Description: rec.Description,
+		Quantity:    math.Abs(quantity),
+		Price:       price,
+		Fees:        math.Abs(commission) + math.Abs(taxes),
+		Amount:      amount,
+	}}, nil
+}
+
+// ibkrCashTransaction maps dividends onto the project's dividend action, other cash movements
+// keep their IBKR type so they are reported as unsupported
+func ibkrCashTransaction(rec ibkrRecord) ([]models.Transaction, error) {
+	date, err := ibkrDate(rec.DateTime, rec.ReportDate)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := parseMonetaryValue(rec.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q", rec.Amount)
+	}
+
+	action := rec.Type
+	if rec.Type == "Dividends" || rec.Type == "Payment In Lieu Of Dividends" {
+		action = "Cash Dividend"
+	}
+
+	return []models.Transaction{{
+		Date:        date,
+		Action:      action,
+		Symbol:      ibkrSymbol(rec),
+		Description: rec.Description,
+		Amount:      amount,
+	}}, nil
+}
+
+// ibkrCorporateAction maps forward (FS) and reverse (RS) splits onto the split actions
+func ibkrCorporateAction(rec ibkrRecord) ([]models.Transaction, error) {
+	date, err := ibkrDate(rec.DateTime, rec.ReportDate)
+	if err != nil {
+		return nil, err
+	}
+	quantity, err := parseMonetaryValue(rec.Quantity)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quantity %q", rec.Quantity)
+	}
+
+	action := rec.Type
+	switch rec.Type {
+	case "FS", "SD":
+		action = "Stock Split"
+		if rec.AssetCategory == "OPT" {
+			action = "Options Frwd Split"
+		}
+	case "RS":
+		action = "Reverse Split"
+	}
+
+	return []models.Transaction{{
+		Date:        date,
+		Action:      action,
+		Symbol:      ibkrSymbol(rec),
+		Description: rec.Description,
+		Quantity:    quantity,
+	}}, nil
+}
+
+// ibkrSymbol maps option contracts onto the "UNDERLYING MM/DD/YYYY STRIKE C/P" convention using
+// the contract attributes, falling back to the OCC symbol
+func ibkrSymbol(rec ibkrRecord) string {
+	if rec.AssetCategory != "OPT" {
+		return rec.Symbol
+	}
+	strike, strikeErr := strconv.ParseFloat(rec.Strike, 64)
+	expiry, expiryErr := ibkrDate(rec.Expiry)
+	if rec.UnderlyingSymbol != "" && (rec.PutCall == "C" || rec.PutCall == "P") && strikeErr == nil && expiryErr == nil {
+		return models.OptionSymbol{
+			Underlying: rec.UnderlyingSymbol,
+			Expiration: expiry,
+			Strike:     strike,
+			Type:       rec.PutCall,
+		}.String()
+	}
+	if occ, ok := models.ParseOCCSymbol(rec.Symbol); ok {
+		return occ.String()
+	}
+	return rec.Symbol
+}
+
+// ibkrHasNote reports whether the semicolon separated notes contain the code
+func ibkrHasNote(notes, code string) bool {
+	for _, note := range strings.Split(notes, ";") {
+		if strings.TrimSpace(note) == code {
+			return true
+		}
+	}
+	return false
+}
+
+// ibkrDate parses the first non-empty Flex date, which may be followed by a time ("20240105;093000")
+func ibkrDate(values ...string) (time.Time, error) {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if i := strings.IndexAny(value, "; ,"); i > 0 {
+			value = value[:i]
+		}
+		for _, layout := range []string{"20060102", "2006-01-02", "01/02/2006"} {
+			if date, err := parseDate(value, layout); err == nil {
+				return date, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return time.Time{}, fmt.Errorf("missing date")
+}
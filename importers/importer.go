@@ -0,0 +1,149 @@
+package importers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"stock-portfolio-api/models"
+)
+
+// DetectSize is how much of the start of a file is handed to Detect
+const DetectSize = 4096
+
+// Importer parses a brokerage export and normalizes each row into a models.Transaction
+type Importer interface {
+	// Name is the format name the importer is registered under
+	Name() string
+	// Detect reports whether the start of a file looks like this format
+	Detect(head []byte) bool
+	// Parse streams the rows of the file to emit as they are decoded, stopping at the first
+	// error emit returns
+	Parse(r io.Reader, emit func(Row) error) error
+}
+
+// Row is a single source row normalized into a Transaction. Rows that could not be
+// normalized carry the reason in Err.
+type Row struct {
+	Ordinal     int // position of the row within the file, starting at 1
+	Transaction models.Transaction
+	Err         error
+}
+
+// ParseAll collects every row of the file
+func ParseAll(imp Importer, r io.Reader) ([]Row, error) {
+	var rows []Row
+	err := imp.Parse(r, func(row Row) error {
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
+
+type registered struct {
+	importer Importer
+	priority int
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]registered{}
+)
+
+// Register makes an importer available by its format name. Importers with a lower
+// priority are tried first during detection so specific formats win over generic ones.
+func Register(imp Importer, priority int) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[imp.Name()] = registered{importer: imp, priority: priority}
+}
+
+// Get returns the importer registered under the format name
+func Get(name string) (Importer, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	r, ok := registry[name]
+	return r.importer, ok
+}
+
+// Formats returns the registered format names in detection order
+func Formats() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	entries := make([]registered, 0, len(registry))
+	for _, r := range registry {
+		entries = append(entries, r)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].priority != entries[j].priority {
+			return entries[i].priority < entries[j].priority
+		}
+		return entries[i].importer.Name() < entries[j].importer.Name()
+	})
+	names := make([]string, len(entries))
+	for i, r := range entries {
+		names[i] = r.importer.Name()
+	}
+	return names
+}
+
+// Detect finds the importer for a file from the start of its content
+func Detect(head []byte) (Importer, error) {
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	for _, name := range Formats() {
+		imp, _ := Get(name)
+		if imp.Detect(head) {
+			return imp, nil
+		}
+	}
+	return nil, fmt.Errorf("unrecognized import file format")
+}
+
+// parseDate parses a date in the given layout and moves it to the beginning of the day in local time
+func parseDate(value, layout string) (time.Time, error) {
+	date, err := time.Parse(layout, strings.TrimSpace(extractCorrectDate(value)))
+	if err != nil {
+		return time.Time{}, err
+	}
+	location, _ := time.LoadLocation("Local")
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, location), nil
+}
+
+// Helper function to extract the correct date from the date string
+func extractCorrectDate(dateStr string) string {
+	// Split the date string by " as of "
+	parts := strings.Split(dateStr, " as of ")
+	// Return the last part
+	return parts[len(parts)-1]
+}
+
+// Helper function to parse monetary values by removing $ and , (empty values are zero)
+func parseMonetaryValue(value string) (float64, error) {
+	cleanedValue := strings.TrimSpace(strings.ReplaceAll(strings.ReplaceAll(value, "$", ""), ",", ""))
+	if cleanedValue == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(cleanedValue, 64)
+}
+
+// parseValues parses the monetary columns of a row, naming the first column that failed
+func parseValues(quantity, price, fees, amount string) (q, p, f, a float64, err error) {
+	if q, err = parseMonetaryValue(quantity); err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("invalid quantity %q", quantity)
+	}
+	if p, err = parseMonetaryValue(price); err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("invalid price %q", price)
+	}
+	if f, err = parseMonetaryValue(fees); err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("invalid fees %q", fees)
+	}
+	if a, err = parseMonetaryValue(amount); err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	return q, p, f, a, nil
+}
@@ -0,0 +1,432 @@
+package importers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"stock-portfolio-api/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const schwabJSON = `{
+  "FromDate": "01/01/2024",
+  "ToDate": "01/31/2024",
+  "BrokerageTransactions": [
+    {"Date": "01/05/2024", "Action": "Buy", "Symbol": "AAPL", "Description": "APPLE INC", "Quantity": "100", "Price": "$180.00", "Fees & Comm": "", "Amount": "-$18,000.00"},
+    {"Date": "01/08/2024 as of 01/05/2024", "Action": "Sell to Open", "Symbol": "AAPL 02/16/2024 200.00 C", "Description": "CALL APPLE INC $200 EXP 02/16/24", "Quantity": "1", "Price": "$2.10", "Fees & Comm": "$0.66", "Amount": "$209.34"}
+  ]
+}`
+
+const schwabCSV = `"Transactions  for account XXXX-1234 as of 01/31/2024 10:00:00 ET"
+"Date","Action","Symbol","Description","Quantity","Price","Fees & Comm","Amount",
+"01/05/2024","Buy","AAPL","APPLE INC","100","$180.00","","-$18,000.00",
+"01/05/2024","Sell to Open","AAPL 02/16/2024 200.00 C","CALL APPLE INC $200 EXP 02/16/24","1","$2.10","$0.66","$209.34",
+"Transactions Total","","","","","","","-$17,790.66",
+`
+
+const fidelityCSV = `
+
+Run Date,Action,Symbol,Description,Type,Quantity,Price ($),Commission ($),Fees ($),Accrued Interest ($),Amount ($),Settlement Date
+01/05/2024,YOU BOUGHT APPLE INC (AAPL) (Cash),AAPL,APPLE INC,Cash,100,180,,,,-18000,01/09/2024
+01/05/2024,YOU SOLD OPENING TRANSACTION CALL (AAPL) APPLE INC FEB 16 24 $200 (100 SHS) (Margin),-AAPL240216C200,CALL (AAPL) APPLE INC FEB 16 24 $200 (100 SHS),Margin,-1,2.10,0.65,0.01,,209.34,01/08/2024
+
+"The data and information in this spreadsheet is provided to you solely for your use."
+`
+
+const genericCSV = `Trade Date,Type,Ticker,Shares,Cost,Total
+2024-01-05,BOUGHT,AAPL,100,180,-18000
+`
+
+func TestDetect(t *testing.T) {
+	Convey("Given files in each supported format", t, func() {
+		Convey("Schwab JSON should be detected", func() {
+			imp, err := Detect([]byte(schwabJSON))
+			So(err, ShouldBeNil)
+			So(imp.Name(), ShouldEqual, "schwab-json")
+		})
+
+		Convey("Schwab CSV should be detected ahead of the generic CSV", func() {
+			imp, err := Detect([]byte(schwabCSV))
+			So(err, ShouldBeNil)
+			So(imp.Name(), ShouldEqual, "schwab-csv")
+		})
+
+		Convey("Fidelity CSV should be detected", func() {
+			imp, err := Detect([]byte(fidelityCSV))
+			So(err, ShouldBeNil)
+			So(imp.Name(), ShouldEqual, "fidelity-csv")
+		})
+
+		Convey("An unknown file should not be detected", func() {
+			_, err := Detect([]byte("hello world"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSchwabImporters(t *testing.T) {
+	Convey("Given the same transactions as Schwab JSON and CSV", t, func() {
+		jsonRows, err := ParseAll(SchwabJSON{}, strings.NewReader(schwabJSON))
+		So(err, ShouldBeNil)
+		csvRows, err := ParseAll(SchwabCSV{}, strings.NewReader(schwabCSV))
+		So(err, ShouldBeNil)
+
+		Convey("Both should normalize into the same transactions", func() {
+			So(jsonRows, ShouldHaveLength, 2)
+			So(csvRows, ShouldHaveLength, 2)
+			for i := range jsonRows {
+				So(jsonRows[i].Err, ShouldBeNil)
+				So(csvRows[i].Err, ShouldBeNil)
+				So(csvRows[i].Transaction.Date.Equal(jsonRows[i].Transaction.Date), ShouldBeTrue)
+				So(csvRows[i].Transaction.Action, ShouldEqual, jsonRows[i].Transaction.Action)
+				So(csvRows[i].Transaction.Symbol, ShouldEqual, jsonRows[i].Transaction.Symbol)
+				So(csvRows[i].Transaction.Amount, ShouldEqual, jsonRows[i].Transaction.Amount)
+			}
+			So(jsonRows[0].Transaction.Amount, ShouldEqual, -18000)
+			So(jsonRows[1].Transaction.Fees, ShouldEqual, 0.66)
+			So(jsonRows[1].Transaction.Date.Day(), ShouldEqual, 5)
+		})
+	})
+}
+
+func TestFidelityCSV(t *testing.T) {
+	Convey("Given a Fidelity history export", t, func() {
+		rows, err := ParseAll(FidelityCSV{}, strings.NewReader(fidelityCSV))
+		So(err, ShouldBeNil)
+		So(rows, ShouldHaveLength, 2)
+
+		Convey("Actions and option symbols should be mapped onto the project's conventions", func() {
+			So(rows[0].Transaction.Action, ShouldEqual, "Buy")
+			So(rows[0].Transaction.Symbol, ShouldEqual, "AAPL")
+			So(rows[1].Transaction.Action, ShouldEqual, "Sell to Open")
+			So(rows[1].Transaction.Symbol, ShouldEqual, "AAPL 02/16/2024 200.00 C")
+			So(rows[1].Transaction.Fees, ShouldAlmostEqual, 0.66, 0.0001)
+			So(rows[1].Transaction.Amount, ShouldEqual, 209.34)
+		})
+	})
+}
+
+func TestGenericCSV(t *testing.T) {
+	Convey("Given a CSV with custom columns and a mapping", t, func() {
+		imp := NewGenericCSV(ColumnMapping{
+			Date:       "Trade Date",
+			Action:     "Type",
+			Symbol:     "Ticker",
+			Quantity:   "Shares",
+			Price:      "Cost",
+			Amount:     "Total",
+			DateFormat: "2006-01-02",
+			Actions:    map[string]string{"BOUGHT": "Buy"},
+		})
+
+		Convey("Rows should be read through the mapping", func() {
+			rows, err := ParseAll(imp, strings.NewReader(genericCSV))
+			So(err, ShouldBeNil)
+			So(rows, ShouldHaveLength, 1)
+			So(rows[0].Err, ShouldBeNil)
+			So(rows[0].Transaction.Action, ShouldEqual, "Buy")
+			So(rows[0].Transaction.Symbol, ShouldEqual, "AAPL")
+			So(rows[0].Transaction.Quantity, ShouldEqual, 100)
+			So(rows[0].Transaction.Amount, ShouldEqual, -18000)
+		})
+
+		Convey("Rows that cannot be parsed should carry an error", func() {
+			rows, err := ParseAll(imp, strings.NewReader("Trade Date,Type,Ticker,Shares\nyesterday,BOUGHT,AAPL,100\n"))
+			So(err, ShouldBeNil)
+			So(rows, ShouldHaveLength, 1)
+			So(rows[0].Err, ShouldNotBeNil)
+		})
+	})
+}
+
+const ofxStatement = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<INVSTMTMSGSRSV1>
+<INVSTMTTRNRS>
+<TRNUID>1
+<INVSTMTRS>
+<DTASOF>20240131
+<CURDEF>USD
+<INVTRANLIST>
+<DTSTART>20240101
+<DTEND>20240131
+<BUYSTOCK>
+<INVBUY>
+<INVTRAN><FITID>1001<DTTRADE>20240105120000.000[-5:EST]<MEMO>BOUGHT AAPL</INVTRAN>
+<SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID>
+<UNITS>100<UNITPRICE>180.00<COMMISSION>0<TOTAL>-18000.00
+<SUBACCTSEC>CASH<SUBACCTFUND>CASH
+</INVBUY>
+<BUYTYPE>BUY
+</BUYSTOCK>
+<SELLOPT>
+<INVSELL>
+<INVTRAN><FITID>1002<DTTRADE>20240105<MEMO>SOLD CALL</INVTRAN>
+<SECID><UNIQUEID>AAPL240216C00200000<UNIQUEIDTYPE>OCC</SECID>
+<UNITS>-1<UNITPRICE>2.10<COMMISSION>0.65<FEES>0.01<TOTAL>209.34
+<SUBACCTSEC>CASH<SUBACCTFUND>CASH
+</INVSELL>
+<OPTSELLTYPE>SELLTOOPEN
+<SHPERCTRCT>100
+</SELLOPT>
+<REINVEST>
+<INVTRAN><FITID>1003<DTTRADE>20240115<MEMO>DIVIDEND REINVESTED</INVTRAN>
+<SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID>
+<INCOMETYPE>DIV<TOTAL>-24.00<SUBACCTSEC>CASH<UNITS>0.1300<UNITPRICE>184.62
+</REINVEST>
+</INVTRANLIST>
+</INVSTMTRS>
+</INVSTMTTRNRS>
+</INVSTMTMSGSRSV1>
+<SECLISTMSGSRSV1>
+<SECLIST>
+<STOCKINFO><SECINFO><SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID><SECNAME>APPLE INC<TICKER>AAPL</SECINFO></STOCKINFO>
+<OPTINFO><SECINFO><SECID><UNIQUEID>AAPL240216C00200000<UNIQUEIDTYPE>OCC</SECID><SECNAME>AAPL Feb 16 2024 200 Call<TICKER>AAPL  240216C00200000</SECINFO>
+<OPTTYPE>CALL<STRIKEPRICE>200.00<DTEXPIRE>20240216<SHPERCTRCT>100
+<SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID>
+</OPTINFO>
+</SECLIST>
+</SECLISTMSGSRSV1>
+</OFX>
+`
+
+func TestOFX(t *testing.T) {
+	Convey("Given an OFX investment statement", t, func() {
+		imp, err := Detect([]byte(ofxStatement))
+		So(err, ShouldBeNil)
+		So(imp.Name(), ShouldEqual, "ofx")
+
+		rows, err := ParseAll(imp, strings.NewReader(ofxStatement))
+		So(err, ShouldBeNil)
+		So(rows, ShouldHaveLength, 4)
+		for _, row := range rows {
+			So(row.Err, ShouldBeNil)
+		}
+
+		Convey("Stock trades should resolve their ticker from the security list", func() {
+			So(rows[0].Transaction.Action, ShouldEqual, "Buy")
+			So(rows[0].Transaction.Symbol, ShouldEqual, "AAPL")
+			So(rows[0].Transaction.Quantity, ShouldEqual, 100)
+			So(rows[0].Transaction.Amount, ShouldEqual, -18000)
+		})
+
+		Convey("Option trades should carry the contract details", func() {
+			So(rows[1].Transaction.Action, ShouldEqual, "Sell to Open")
+			So(rows[1].Transaction.Symbol, ShouldEqual, "AAPL 02/16/2024 200.00 C")
+			So(rows[1].Transaction.Quantity, ShouldEqual, 1)
+			So(rows[1].Transaction.Fees, ShouldAlmostEqual, 0.66, 0.0001)
+		})
+
+		Convey("Reinvested income should become a dividend row and a reinvested lot", func() {
+			So(rows[2].Transaction.Action, ShouldEqual, "Reinvest Dividend")
+			So(rows[2].Transaction.Amount, ShouldEqual, 24)
+			So(rows[3].Transaction.Action, ShouldEqual, "Reinvest Shares")
+			So(rows[3].Transaction.Symbol, ShouldEqual, "AAPL")
+			So(rows[3].Transaction.Quantity, ShouldEqual, 0.13)
+			So(rows[3].Transaction.Amount, ShouldEqual, -24)
+		})
+	})
+}
+
+// ofxEmptyLeaves has empty SGML leaves in the middle of aggregates
+const ofxEmptyLeaves = `OFXHEADER:100
+DATA:OFXSGML
+
+<OFX>
+<INVSTMTMSGSRSV1>
+<INVSTMTTRNRS>
+<TRNUID>1
+<INVSTMTRS>
+<INVTRANLIST>
+<INVBANKTRAN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20240120
+<MEMO>
+<NAME>
+<TRNAMT>500.00
+<FITID>2001
+</STMTTRN>
+<SUBACCTFUND>CASH
+</INVBANKTRAN>
+<BUYSTOCK>
+<INVBUY>
+<INVTRAN><FITID>2002<DTTRADE>20240122<MEMO></INVTRAN>
+<SECID><UNIQUEID>MSFT<UNIQUEIDTYPE>TICKER</SECID>
+<UNITS>10<UNITPRICE>400.00<COMMISSION><TOTAL>-4000.00
+</INVBUY>
+<BUYTYPE>BUY
+</BUYSTOCK>
+</INVTRANLIST>
+</INVSTMTRS>
+</INVSTMTTRNRS>
+</INVSTMTMSGSRSV1>
+</OFX>
+`
+
+func TestOFXEmptyLeaves(t *testing.T) {
+	Convey("Empty leaves should not swallow the fields after them", t, func() {
+		rows, err := ParseAll(OFX{}, strings.NewReader(ofxEmptyLeaves))
+		So(err, ShouldBeNil)
+		So(rows, ShouldHaveLength, 2)
+
+		So(rows[0].Transaction.Action, ShouldEqual, "INVBANKTRAN")
+		So(rows[0].Transaction.Description, ShouldEqual, "")
+		So(rows[0].Transaction.Amount, ShouldEqual, 500)
+
+		So(rows[1].Err, ShouldBeNil)
+		So(rows[1].Transaction.Action, ShouldEqual, "Buy")
+		So(rows[1].Transaction.Symbol, ShouldEqual, "MSFT")
+		So(rows[1].Transaction.Quantity, ShouldEqual, 10)
+		So(rows[1].Transaction.Amount, ShouldEqual, -4000)
+	})
+}
+
+// ofxUnlistedElements has a leaf whose name ends like a response wrapper and an aggregate
+// missing from the known aggregates
+const ofxUnlistedElements = `OFXHEADER:100
+DATA:OFXSGML
+
+<OFX>
+<INVSTMTMSGSRSV1>
+<INVSTMTTRNRS>
+<TRNUID>1
+<INVSTMTRS>
+<INVTRANLIST>
+<BUYSTOCK>
+<INVBUY>
+<INVTRAN><INTU.USERS>2<FITID>3001<DTTRADE>20240122</INVTRAN>
+<SECID><UNIQUEID>MSFT<UNIQUEIDTYPE>TICKER</SECID>
+<UNITS>10<UNITPRICE>400.00<TOTAL>-4000.00
+</INVBUY>
+<BUYTYPE>BUY
+</BUYSTOCK>
+<INTU.XFER>
+<INVTRAN><FITID>3002<DTTRADE>20240123</INVTRAN>
+<SECID><UNIQUEID>AAPL<UNIQUEIDTYPE>TICKER</SECID>
+<UNITS>5
+</INTU.XFER>
+</INVTRANLIST>
+</INVSTMTRS>
+</INVSTMTTRNRS>
+</INVSTMTMSGSRSV1>
+</OFX>
+`
+
+func TestOFXUnlistedElements(t *testing.T) {
+	Convey("Elements should be told apart by the known aggregates and their closing tags, not their names", t, func() {
+		rows, err := ParseAll(OFX{}, strings.NewReader(ofxUnlistedElements))
+		So(err, ShouldBeNil)
+		So(rows, ShouldHaveLength, 2)
+
+		So(rows[0].Err, ShouldBeNil)
+		So(rows[0].Transaction.Action, ShouldEqual, "Buy")
+		So(rows[0].Transaction.Symbol, ShouldEqual, "MSFT")
+		So(rows[0].Transaction.Amount, ShouldEqual, -4000)
+
+		So(rows[1].Err, ShouldBeNil)
+		So(rows[1].Transaction.Action, ShouldEqual, "INTU.XFER")
+		So(rows[1].Transaction.Symbol, ShouldEqual, "AAPL")
+	})
+}
+
+const ibkrFlex = `<?xml version="1.0" encoding="UTF-8"?>
+<FlexQueryResponse queryName="Activity" type="AF">
+<FlexStatements count="1">
+<FlexStatement accountId="U1234567" fromDate="20240101" toDate="20240131">
+<Trades>
+<Trade assetCategory="STK" symbol="AAPL" description="APPLE INC" tradeDate="20240105" quantity="100" tradePrice="180" ibCommission="-1" netCash="-18001" buySell="BUY" openCloseIndicator="O" levelOfDetail="EXECUTION" />
+<Trade assetCategory="OPT" symbol="AAPL  240216C00200000" description="AAPL 16FEB24 200 C" underlyingSymbol="AAPL" putCall="C" strike="200" expiry="20240216" tradeDate="20240105" quantity="-1" tradePrice="2.10" ibCommission="-0.65" netCash="209.35" buySell="SELL" openCloseIndicator="O" levelOfDetail="EXECUTION" />
+<Trade assetCategory="OPT" symbol="MSFT  240216P00350000" description="MSFT 16FEB24 350 P" tradeDate="20240216" quantity="1" tradePrice="0" ibCommission="0" netCash="0" buySell="BUY" openCloseIndicator="C" notes="Ep" />
+</Trades>
+<CashTransactions>
+<CashTransaction type="Dividends" symbol="AAPL" description="AAPL CASH DIVIDEND USD 0.24 PER SHARE" dateTime="20240215;202000" amount="24" />
+</CashTransactions>
+<CorporateActions>
+<CorporateAction assetCategory="STK" type="FS" symbol="NVDA" description="NVDA SPLIT 10 FOR 1" dateTime="20240610" quantity="90" />
+</CorporateActions>
+</FlexStatement>
+</FlexStatements>
+</FlexQueryResponse>
+`
+
+func TestIBKRFlex(t *testing.T) {
+	Convey("Given an IBKR Flex Query report", t, func() {
+		imp, err := Detect([]byte(ibkrFlex))
+		So(err, ShouldBeNil)
+		So(imp.Name(), ShouldEqual, "ibkr-flex")
+
+		rows, err := ParseAll(imp, strings.NewReader(ibkrFlex))
+		So(err, ShouldBeNil)
+		So(rows, ShouldHaveLength, 5)
+		for _, row := range rows {
+			So(row.Err, ShouldBeNil)
+		}
+
+		Convey("Trades should map onto the project's actions", func() {
+			So(rows[0].Transaction.Action, ShouldEqual, "Buy")
+			So(rows[0].Transaction.Fees, ShouldEqual, 1)
+			So(rows[1].Transaction.Action, ShouldEqual, "Sell to Open")
+			So(rows[1].Transaction.Quantity, ShouldEqual, 1)
+			So(rows[2].Transaction.Action, ShouldEqual, "Expired")
+		})
+
+		Convey("OCC option symbols should map onto the project's symbol convention", func() {
+			So(rows[1].Transaction.Symbol, ShouldEqual, "AAPL 02/16/2024 200.00 C")
+			So(rows[2].Transaction.Symbol, ShouldEqual, "MSFT 02/16/2024 350.00 P")
+		})
+
+		Convey("Cash transactions and corporate actions should be included", func() {
+			So(rows[3].Transaction.Action, ShouldEqual, "Cash Dividend")
+			So(rows[3].Transaction.Amount, ShouldEqual, 24)
+			So(rows[3].Transaction.Date.Day(), ShouldEqual, 15)
+			So(rows[4].Transaction.Action, ShouldEqual, "Stock Split")
+			So(rows[4].Transaction.Quantity, ShouldEqual, 90)
+		})
+	})
+}
+
+func TestSchwabExport(t *testing.T) {
+	Convey("Given stored transactions", t, func() {
+		date := time.Date(2024, 1, 8, 0, 0, 0, 0, time.Local)
+		transactions := []models.Transaction{
+			{Date: date, Action: "Buy", Symbol: "AAPL", Description: "APPLE INC", Quantity: 100, Price: 180, Amount: -18000},
+			{Date: date, Action: "Sell to Open", Symbol: "AAPL 02/16/2024 200.00 C", Description: "CALL APPLE INC", Quantity: -1, Price: 2.105, Fees: 0.66, Amount: 209.84},
+		}
+
+		Convey("The CSV and JSON exports should import back into the same transactions", func() {
+			var csvOut, jsonOut strings.Builder
+			So(WriteSchwabCSV(&csvOut, transactions), ShouldBeNil)
+			So(WriteSchwabJSON(&jsonOut, transactions), ShouldBeNil)
+
+			imp, err := Detect([]byte(csvOut.String()))
+			So(err, ShouldBeNil)
+			So(imp.Name(), ShouldEqual, "schwab-csv")
+			imp, err = Detect([]byte(jsonOut.String()))
+			So(err, ShouldBeNil)
+			So(imp.Name(), ShouldEqual, "schwab-json")
+
+			for _, rows := range [][]Row{mustParse(SchwabCSV{}, csvOut.String()), mustParse(SchwabJSON{}, jsonOut.String())} {
+				So(rows, ShouldHaveLength, 2)
+				So(rows[0].Err, ShouldBeNil)
+				So(rows[0].Transaction.Amount, ShouldEqual, -18000)
+				So(rows[1].Transaction.Date.Equal(date), ShouldBeTrue)
+				So(rows[1].Transaction.Symbol, ShouldEqual, "AAPL 02/16/2024 200.00 C")
+				So(rows[1].Transaction.Quantity, ShouldEqual, 1)
+				So(rows[1].Transaction.Price, ShouldEqual, 2.105)
+				So(rows[1].Transaction.Fees, ShouldEqual, 0.66)
+			}
+		})
+	})
+}
+
+func mustParse(imp Importer, content string) []Row {
+	rows, err := ParseAll(imp, strings.NewReader(content))
+	So(err, ShouldBeNil)
+	return rows
+}
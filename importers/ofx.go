@@ -0,0 +1,463 @@
+package importers
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"stock-portfolio-api/models"
+)
+
+// OFX imports OFX and QFX investment statements (INVSTMTRS), both the SGML (v1) and XML (v2) flavors
+type OFX struct{}
+
+func init() {
+	Register(OFX{}, 10)
+}
+
+func (OFX) Name() string {
+	return "ofx"
+}
+
+func (OFX) Detect(head []byte) bool {
+	upper := bytes.ToUpper(head)
+	return bytes.Contains(upper, []byte("OFXHEADER")) || bytes.Contains(upper, []byte("<OFX>"))
+}
+
+// Parse builds the whole document tree since SGML OFX can't be decoded incrementally, rows are
+// still emitted one at a time
+func (OFX) Parse(r io.Reader, emit func(Row) error) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	root, err := parseOFX(string(data))
+	if err != nil {
+		return err
+	}
+
+	statement := root.find("INVSTMTMSGSRSV1", "INVSTMTTRNRS", "INVSTMTRS")
+	if statement == nil {
+		return fmt.Errorf("no investment statement (INVSTMTRS) in OFX file")
+	}
+	securities := ofxSecurities(root.find("SECLISTMSGSRSV1", "SECLIST"))
+
+	tranList := statement.find("INVTRANLIST")
+	if tranList == nil {
+		return nil
+	}
+	ordinal := 0
+	for _, n := range tranList.children {
+		// DTSTART and DTEND are leaf elements of the list itself
+		if len(n.children) == 0 {
+			continue
+		}
+		for _, t := range ofxTransactions(n, securities) {
+			ordinal++
+			if err := emit(Row{Ordinal: ordinal, Transaction: t.transaction, Err: t.err}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ofxNode is an element of an OFX document, leaf elements carry text
+type ofxNode struct {
+	name     string
+	text     string
+	children []*ofxNode
+}
+
+// find walks down the path of child element names
+func (n *ofxNode) find(path ...string) *ofxNode {
+	current := n
+	for _, name := range path {
+		var next *ofxNode
+		for _, child := range current.children {
+			if child.name == name {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
+
+// value returns the text of the element at path, or "" when it is missing
+func (n *ofxNode) value(path ...string) string {
+	if found := n.find(path...); found != nil {
+		return found.text
+	}
+	return ""
+}
+
+// ofxAggregates are the elements that contain other elements, every other element is a leaf
+var ofxAggregates = map[string]bool{
+	"OFX": true, "STATUS": true, "FI": true,
+	// Message sets and their request/response wrappers
+	"SIGNONMSGSRQV1": true, "SIGNONMSGSRSV1": true, "SONRQ": true, "SONRS": true,
+	"SIGNUPMSGSRQV1": true, "SIGNUPMSGSRSV1": true, "ACCTINFOTRNRQ": true, "ACCTINFOTRNRS": true,
+	"ACCTINFORQ": true, "ACCTINFORS": true, "ACCTINFO": true, "BANKACCTINFO": true, "CCACCTINFO": true,
+	"INVACCTINFO": true, "BANKMSGSRQV1": true, "BANKMSGSRSV1": true, "STMTTRNRQ": true, "STMTTRNRS": true,
+	"STMTRQ": true, "STMTENDTRNRQ": true, "STMTENDTRNRS": true, "STMTENDRQ": true, "STMTENDRS": true,
+	"CREDITCARDMSGSRQV1": true, "CREDITCARDMSGSRSV1": true, "CCSTMTTRNRQ": true, "CCSTMTTRNRS": true,
+	"CCSTMTRQ": true, "CCSTMTENDTRNRQ": true, "CCSTMTENDTRNRS": true, "CCSTMTENDRQ": true, "CCSTMTENDRS": true,
+	"INVSTMTMSGSRQV1": true, "INVSTMTMSGSRSV1": true, "INVSTMTTRNRQ": true, "INVSTMTTRNRS": true,
+	"INVSTMTRQ": true, "INCTRAN": true, "SECLISTMSGSRQV1": true, "SECLISTMSGSRSV1": true,
+	"SECLISTTRNRQ": true, "SECLISTTRNRS": true, "SECLISTRQ": true, "SECLISTRS": true, "SECRQ": true,
+	"PROFMSGSRQV1": true, "PROFMSGSRSV1": true, "PROFTRNRQ": true, "PROFTRNRS": true, "PROFRQ": true, "PROFRS": true,
+	// Statements
+	"INVSTMTRS": true, "INVACCTFROM": true, "INVTRANLIST": true, "INVPOSLIST": true, "INVBAL": true,
+	"INVOOLIST": true, "INV401K": true, "INV401KBAL": true, "BALLIST": true, "BAL": true,
+	"STMTRS": true, "CCSTMTRS": true, "BANKACCTFROM": true, "CCACCTFROM": true, "BANKACCTTO": true,
+	"CCACCTTO": true, "BANKTRANLIST": true, "LEDGERBAL": true, "AVAILBAL": true, "STMTTRN": true,
+	"PAYEE": true, "CURRENCY": true, "ORIGCURRENCY": true,
+	// Investment transactions, positions and securities
+	"INVBANKTRAN": true, "INVTRAN": true, "INVBUY": true, "INVSELL": true, "SECID": true,
+	"BUYDEBT": true, "BUYMF": true, "BUYOPT": true, "BUYOTHER": true, "BUYSTOCK": true,
+	"SELLDEBT": true, "SELLMF": true, "SELLOPT": true, "SELLOTHER": true, "SELLSTOCK": true,
+	"CLOSUREOPT": true, "INCOME": true, "INVEXPENSE": true, "JRNLFUND": true, "JRNLSEC": true,
+	"MARGININTEREST": true, "REINVEST": true, "RETOFCAP": true, "SPLIT": true, "TRANSFER": true,
+	"INVPOS": true, "POSDEBT": true, "POSMF": true, "POSOPT": true, "POSOTHER": true, "POSSTOCK": true,
+	"OO": true, "OOBUYDEBT": true, "OOBUYMF": true, "OOBUYOPT": true, "OOBUYOTHER": true, "OOBUYSTOCK": true,
+	"OOSELLDEBT": true, "OOSELLMF": true, "OOSELLOPT": true, "OOSELLOTHER": true, "OOSELLSTOCK": true,
+	"SWITCHMF": true, "SECLIST": true, "SECINFO": true, "DEBTINFO": true, "MFINFO": true, "OPTINFO": true,
+	"OTHERINFO": true, "STOCKINFO": true, "MFASSETCLASS": true, "FIMFASSETCLASS": true, "PORTION": true,
+	"FIPORTION": true,
+}
+
+// ofxClosedElements returns the names of the elements closed anywhere in the document. Leaves
+// are rarely closed in SGML, so a closed element that isn't a known aggregate is treated as one
+// and keeps its children.
+func ofxClosedElements(data string) map[string]bool {
+	closed := map[string]bool{}
+	for {
+		start := strings.Index(data, "</")
+		if start < 0 {
+			return closed
+		}
+		data = data[start+2:]
+		end := strings.IndexByte(data, '>')
+		if end < 0 {
+			return closed
+		}
+		closed[strings.ToUpper(strings.TrimSpace(data[:end]))] = true
+		data = data[end+1:]
+	}
+}
+
+// parseOFX builds the element tree. OFX v1 is SGML where leaf elements are not closed,
+// so a leaf element is closed implicitly by the next tag.
+func parseOFX(data string) (*ofxNode, error) {
+	start := strings.Index(strings.ToUpper(data), "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("missing <OFX> element")
+	}
+	data = data[start:]
+
+	closed := ofxClosedElements(data)
+	root := &ofxNode{}
+	stack := []*ofxNode{root}
+	for len(data) > 0 {
+		open := strings.IndexByte(data, '<')
+		if open < 0 {
+			break
+		}
+		if text := strings.TrimSpace(data[:open]); text != "" {
+			stack[len(stack)-1].text = html.UnescapeString(text)
+		}
+		end := strings.IndexByte(data[open:], '>')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated tag in OFX file")
+		}
+		tag := strings.TrimSpace(data[open+1 : open+end])
+		data = data[open+end+1:]
+
+		if strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!") {
+			continue
+		}
+
+		// An unclosed leaf element ends where the next tag begins, even when it is empty
+		if top := stack[len(stack)-1]; len(stack) > 1 && len(top.children) == 0 && !ofxAggregates[top.name] && !closed[top.name] {
+			stack = stack[:len(stack)-1]
+		}
+
+		if strings.HasPrefix(tag, "/") {
+			name := strings.ToUpper(strings.TrimSpace(tag[1:]))
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			continue
+		}
+
+		node := &ofxNode{name: strings.ToUpper(tag)}
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, node)
+		stack = append(stack, node)
+	}
+	return root.find("OFX"), nil
+}
+
+// ofxSecurity is the symbol information of a security in the statement's SECLIST
+type ofxSecurity struct {
+	ticker string
+	name   string
+	option *ofxOption
+}
+
+type ofxOption struct {
+	optType       string
+	strike        float64
+	expiration    time.Time
+	underlyingKey string
+}
+
+// ofxSecurities indexes the securities of a SECLIST by their SECID
+func ofxSecurities(list *ofxNode) map[string]ofxSecurity {
+	securities := map[string]ofxSecurity{}
+	if list == nil {
+		return securities
+	}
+	for _, info := range list.children {
+		secInfo := info.find("SECINFO")
+		if secInfo == nil {
+			continue
+		}
+		sec := ofxSecurity{
+			ticker: secInfo.value("TICKER"),
+			name:   secInfo.value("SECNAME"),
+		}
+		if info.name == "OPTINFO" {
+			strike, _ := strconv.ParseFloat(info.value("STRIKEPRICE"), 64)
+			expiration, _ := parseOFXDate(info.value("DTEXPIRE"))
+			opt := &ofxOption{
+				optType:    "C",
+				strike:     strike,
+				expiration: expiration,
+			}
+			if info.value("OPTTYPE") == "PUT" {
+				opt.optType = "P"
+			}
+			if underlying := info.find("SECID"); underlying != nil {
+				opt.underlyingKey = ofxSecurityKey(underlying)
+			}
+			sec.option = opt
+		}
+		securities[ofxSecurityKey(secInfo.find("SECID"))] = sec
+	}
+	return securities
+}
+
+func ofxSecurityKey(secID *ofxNode) string {
+	if secID == nil {
+		return ""
+	}
+	return secID.value("UNIQUEIDTYPE") + ":" + secID.value("UNIQUEID")
+}
+
+// ofxSymbol resolves a SECID into the project's symbol convention
+func ofxSymbol(secID *ofxNode, securities map[string]ofxSecurity) (string, string) {
+	sec, ok := securities[ofxSecurityKey(secID)]
+	if !ok {
+		if secID == nil {
+			return "", ""
+		}
+		return secID.value("UNIQUEID"), ""
+	}
+	if sec.option != nil {
+		underlying := securities[sec.option.underlyingKey].ticker
+		if underlying == "" {
+			// Fall back to the root of an OCC style ticker
+			underlying = strings.TrimSpace(strings.SplitN(sec.ticker, " ", 2)[0])
+		}
+		symbol := models.OptionSymbol{
+			Underlying: underlying,
+			Expiration: sec.option.expiration,
+			Strike:     sec.option.strike,
+			Type:       sec.option.optType,
+		}
+		return symbol.String(), sec.name
+	}
+	if sec.ticker == "" {
+		return secID.value("UNIQUEID"), sec.name
+	}
+	return sec.ticker, sec.name
+}
+
+// parseOFXDate parses OFX datetimes such as 20240105120000.000[-5:EST] into the local day
+func parseOFXDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return parseDate(value[:8], "20060102")
+}
+
+type ofxResult struct {
+	transaction models.Transaction
+	err         error
+}
+
+// ofxTransactions maps an investment transaction element onto one or more Transactions
+func ofxTransactions(n *ofxNode, securities map[string]ofxSecurity) []ofxResult {
+	// Cash movements are kept so they are reported as an unsupported action
+	if n.name == "INVBANKTRAN" {
+		date, err := parseOFXDate(n.value("STMTTRN", "DTPOSTED"))
+		if err != nil {
+			return []ofxResult{{err: err}}
+		}
+		amount, _ := strconv.ParseFloat(n.value("STMTTRN", "TRNAMT"), 64)
+		return []ofxResult{{transaction: models.Transaction{
+			Date:        date,
+			Action:      n.name,
+			Description: n.value("STMTTRN", "MEMO"),
+			Amount:      amount,
+		}}}
+	}
+
+	// Buys and sells wrap their details in INVBUY / INVSELL, everything else carries them directly
+	detail := n
+	if inner := n.find("INVBUY"); inner != nil {
+		detail = inner
+	} else if inner := n.find("INVSELL"); inner != nil {
+		detail = inner
+	}
+
+	date, err := parseOFXDate(detail.value("INVTRAN", "DTTRADE"))
+	if err != nil {
+		return []ofxResult{{err: err}}
+	}
+	symbol, name := ofxSymbol(detail.find("SECID"), securities)
+	description := detail.value("INVTRAN", "MEMO")
+	if description == "" {
+		description = name
+	}
+
+	number := func(path ...string) (float64, error) {
+		value := detail.value(path...)
+		if value == "" {
+			return 0, nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q", strings.ToLower(path[len(path)-1]), value)
+		}
+		return f, nil
+	}
+	units, err := number("UNITS")
+	if err != nil {
+		return []ofxResult{{err: err}}
+	}
+	price, err := number("UNITPRICE")
+	if err != nil {
+		return []ofxResult{{err: err}}
+	}
+	commission, err := number("COMMISSION")
+	if err != nil {
+		return []ofxResult{{err: err}}
+	}
+	fees, err := number("FEES")
+	if err != nil {
+		return []ofxResult{{err: err}}
+	}
+	total, err := number("TOTAL")
+	if err != nil {
+		return []ofxResult{{err: err}}
+	}
+
+	transaction := models.Transaction{
+		Date:        date,
+		Symbol:      symbol,
+		Description: description,
+		Quantity:    math.Abs(units),
+		Price:       price,
+		Fees:        commission + fees,
+		Amount:      total,
+	}
+
+	switch n.name {
+	case "BUYSTOCK", "BUYMF", "BUYOTHER", "BUYDEBT":
+		transaction.Action = "Buy"
+	case "SELLSTOCK", "SELLMF", "SELLOTHER", "SELLDEBT":
+		transaction.Action = "Sell"
+		if n.value("SELLTYPE") == "SELLSHORT" {
+			transaction.Action = "Sell Short"
+		}
+	case "BUYOPT":
+		transaction.Action = "Buy to Open"
+		if n.value("OPTBUYTYPE") == "BUYTOCLOSE" {
+			transaction.Action = "Buy to Close"
+		}
+	case "SELLOPT":
+		transaction.Action = "Sell to Open"
+		if n.value("OPTSELLTYPE") == "SELLTOCLOSE" {
+			transaction.Action = "Sell to Close"
+		}
+	case "CLOSUREOPT":
+		switch n.value("OPTACTION") {
+		case "ASSIGN":
+			transaction.Action = "Assigned"
+		case "EXPIRE":
+			transaction.Action = "Expired"
+		default:
+			transaction.Action = "Exchange or Exercise"
+		}
+	case "SPLIT":
+		oldUnits, _ := strconv.ParseFloat(n.value("OLDUNITS"), 64)
+		newUnits, _ := strconv.ParseFloat(n.value("NEWUNITS"), 64)
+		transaction.Quantity = newUnits - oldUnits
+		transaction.Action = "Stock Split"
+		if newUnits < oldUnits {
+			transaction.Action = "Reverse Split"
+		}
+	case "INCOME":
+		transaction.Action = ofxIncomeAction(n.value("INCOMETYPE"), false)
+	case "REINVEST":
+		// Reinvested income is the dividend cash row plus the lot it bought
+		dividend := transaction
+		dividend.Action = ofxIncomeAction(n.value("INCOMETYPE"), true)
+		dividend.Quantity = 0
+		dividend.Price = 0
+		dividend.Amount = math.Abs(total)
+		transaction.Action = "Reinvest Shares"
+		transaction.Amount = -math.Abs(total)
+		return []ofxResult{{transaction: dividend}, {transaction: transaction}}
+	default:
+		// Keep the element name so the row is reported as an unsupported action
+		transaction.Action = n.name
+	}
+	return []ofxResult{{transaction: transaction}}
+}
+
+// ofxIncomeAction maps an OFX INCOMETYPE onto the project's dividend actions
+func ofxIncomeAction(incomeType string, reinvested bool) string {
+	switch incomeType {
+	case "DIV":
+		if reinvested {
+			return "Reinvest Dividend"
+		}
+		return "Cash Dividend"
+	case "INTEREST":
+		return "Credit Interest"
+	case "CGLONG":
+		return "Long Term Cap Gain"
+	case "CGSHORT":
+		return "Short Term Cap Gain"
+	}
+	if reinvested {
+		return "Reinvest Dividend"
+	}
+	return "Misc Income"
+}
@@ -0,0 +1,156 @@
+package importers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"stock-portfolio-api/models"
+)
+
+// schwabRow is a single Schwab transaction, shared by the JSON and CSV exports
+type schwabRow struct {
+	Date        string `json:"Date"`
+	Action      string `json:"Action"`
+	Symbol      string `json:"Symbol"`
+	Description string `json:"Description"`
+	Quantity    string `json:"Quantity"`
+	Price       string `json:"Price"`
+	FeesComm    string `json:"Fees & Comm"`
+	Amount      string `json:"Amount"`
+}
+
+func (bt schwabRow) row(ordinal int) Row {
+	row := Row{Ordinal: ordinal}
+
+	transactionDate, err := parseDate(bt.Date, "01/02/2006")
+	if err != nil {
+		row.Err = fmt.Errorf("invalid date %q", bt.Date)
+		return row
+	}
+
+	quantity, price, fees, amount, err := parseValues(bt.Quantity, bt.Price, bt.FeesComm, bt.Amount)
+	if err != nil {
+		row.Err = err
+		return row
+	}
+
+	row.Transaction = models.Transaction{
+		Date:        transactionDate,
+		Action:      bt.Action,
+		Symbol:      bt.Symbol,
+		Description: bt.Description,
+		Quantity:    quantity,
+		Price:       price,
+		Fees:        fees,
+		Amount:      amount,
+	}
+	return row
+}
+
+// SchwabJSON imports the Schwab JSON export ({"BrokerageTransactions": [...]})
+type SchwabJSON struct{}
+
+// SchwabCSV imports the Schwab CSV export
+type SchwabCSV struct{}
+
+func init() {
+	Register(SchwabJSON{}, 10)
+	Register(SchwabCSV{}, 10)
+}
+
+func (SchwabJSON) Name() string {
+	return "schwab-json"
+}
+
+func (SchwabJSON) Detect(head []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(head), []byte("{")) && bytes.Contains(head, []byte(`"BrokerageTransactions"`))
+}
+
+// Parse streams the BrokerageTransactions array one element at a time so large statements
+// are never fully decoded into memory
+func (SchwabJSON) Parse(r io.Reader, emit func(Row) error) error {
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key, _ := token.(string); key != "BrokerageTransactions" {
+			// Skip the value of any other field
+			var skip json.RawMessage
+			if err := decoder.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := expectDelim(decoder, '['); err != nil {
+			return err
+		}
+		for ordinal := 1; decoder.More(); ordinal++ {
+			var bt schwabRow
+			if err := decoder.Decode(&bt); err != nil {
+				return err
+			}
+			if err := emit(bt.row(ordinal)); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expectDelim reads the next JSON token and checks it is the delimiter
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := token.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %q in JSON file, found %v", delim, token)
+	}
+	return nil
+}
+
+var schwabCSVColumns = []string{"Date", "Action", "Symbol", "Fees & Comm"}
+
+func (SchwabCSV) Name() string {
+	return "schwab-csv"
+}
+
+func (SchwabCSV) Detect(head []byte) bool {
+	_, ok := findHeaderLine(head, schwabCSVColumns...)
+	return ok
+}
+
+func (SchwabCSV) Parse(r io.Reader, emit func(Row) error) error {
+	ordinal := 0
+	return readCSV(r, func(record []string, columns csvColumns) error {
+		ordinal++
+		bt := schwabRow{
+			Date:        columns.get(record, "Date"),
+			Action:      columns.get(record, "Action"),
+			Symbol:      columns.get(record, "Symbol"),
+			Description: columns.get(record, "Description"),
+			Quantity:    columns.get(record, "Quantity"),
+			Price:       columns.get(record, "Price"),
+			FeesComm:    columns.get(record, "Fees & Comm"),
+			Amount:      columns.get(record, "Amount"),
+		}
+		// Older exports end with a "Transactions Total" summary row
+		if strings.HasPrefix(bt.Date, "Transactions Total") {
+			return nil
+		}
+		return emit(bt.row(ordinal))
+	}, schwabCSVColumns...)
+}
@@ -0,0 +1,80 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"stock-portfolio-api/config"
+)
+
+// Mailer sends plain text mail to a single recipient
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// FromConfig returns an SMTP mailer when a mail host is configured and a log-only mailer otherwise
+func FromConfig(cfg *config.Config) Mailer {
+	if cfg.Mail.Host == "" {
+		return LogMailer{}
+	}
+	return &SMTPMailer{
+		Host:     cfg.Mail.Host,
+		Port:     cfg.Mail.Port,
+		Username: cfg.Mail.Username,
+		Password: cfg.Mail.Password,
+		From:     cfg.Mail.From,
+	}
+}
+
+// LogMailer writes mail to the log instead of sending it, for development
+type LogMailer struct{}
+
+func (LogMailer) Send(to, subject, body string) error {
+	log.Printf("Mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// SMTPMailer sends mail through an SMTP server, authenticating when a username is set
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	// Header injection through the address or subject would let a caller add recipients
+	for _, v := range []string{to, subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("invalid mail header %q", v)
+		}
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	port := m.Port
+	if port == "" {
+		port = "25"
+	}
+	return smtp.SendMail(net.JoinHostPort(m.Host, port), auth, m.From, []string{to}, message(m.From, to, subject, body))
+}
+
+func message(from, to, subject, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
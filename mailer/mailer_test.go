@@ -0,0 +1,95 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// smtpStandIn accepts one SMTP conversation and returns the recipients and message it received
+func smtpStandIn(t *testing.T) (string, <-chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		var lines []string
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "MAIL FROM"), strings.HasPrefix(command, "RCPT TO"):
+				lines = append(lines, strings.TrimSpace(line))
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				for {
+					data, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if data == ".\r\n" {
+						break
+					}
+					lines = append(lines, strings.TrimRight(data, "\r\n"))
+				}
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				received <- lines
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := smtpStandIn(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	m := &SMTPMailer{Host: host, Port: port, From: "noreply@example.com"}
+	if err := m.Send("user@example.com", "Reset your password", "Use this link:\nhttps://example.com/reset"); err != nil {
+		t.Fatal(err)
+	}
+
+	conversation := strings.Join(<-received, "\n")
+	for _, expected := range []string{
+		"MAIL FROM:<noreply@example.com>",
+		"RCPT TO:<user@example.com>",
+		"Subject: Reset your password",
+		"https://example.com/reset",
+	} {
+		if !strings.Contains(conversation, expected) {
+			t.Errorf("got conversation %q want it to contain %q", conversation, expected)
+		}
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	m := &SMTPMailer{Host: "127.0.0.1", Port: "1", From: "noreply@example.com"}
+	if err := m.Send("user@example.com\r\nBcc: other@example.com", "Hello", "body"); err == nil {
+		t.Error("expected an error for a recipient with a line break")
+	}
+}
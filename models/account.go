@@ -4,13 +4,48 @@ import "gorm.io/gorm"
 
 type Account struct {
 	gorm.Model
-	ID           uint   `gorm:"primaryKey"`
-	UserID       uint   `gorm:"index"`
-	User         User   `gorm:"foreignKey:UserID"`
-	Name         string `gorm:"size:100"`
-	Balance      float64
-	Positions    []Position    `gorm:"foreignKey:AccountID"`
-	Transactions []Transaction `gorm:"foreignKey:AccountID"`
+	ID              uint   `gorm:"primaryKey"`
+	UserID          uint   `gorm:"index"`
+	User            User   `gorm:"foreignKey:UserID"`
+	Name            string `gorm:"size:100"`
+	Broker          string `gorm:"size:50"`
+	Type            string `gorm:"size:20;default:taxable"`
+	CostBasisMethod string `gorm:"size:20;default:fifo"`
+	Balance         float64
+	Positions       []Position    `gorm:"foreignKey:AccountID"`
+	Transactions    []Transaction `gorm:"foreignKey:AccountID"`
+	// Role is the access of the requesting user, it is set when the account is loaded for a user
+	Role string `gorm:"-"`
+}
+
+// Account types, IRA and Roth accounts are tax-advantaged
+const (
+	AccountTaxable = "taxable"
+	AccountIRA     = "ira"
+	AccountRoth    = "roth"
+)
+
+// Cost basis methods
+const (
+	CostBasisFIFO    = "fifo"
+	CostBasisLIFO    = "lifo"
+	CostBasisHIFO    = "hifo"
+	CostBasisAverage = "average"
+)
+
+// ValidAccountType reports whether t is one of the account types
+func ValidAccountType(t string) bool {
+	return t == AccountTaxable || t == AccountIRA || t == AccountRoth
+}
+
+// ValidCostBasisMethod reports whether m is one of the cost basis methods
+func ValidCostBasisMethod(m string) bool {
+	return m == CostBasisFIFO || m == CostBasisLIFO || m == CostBasisHIFO || m == CostBasisAverage
+}
+
+// TaxAdvantaged reports whether the account is an IRA or Roth, where gains are sheltered from tax
+func (a Account) TaxAdvantaged() bool {
+	return a.Type == AccountIRA || a.Type == AccountRoth
 }
 
 // CreateAccount creates a new account in the database
@@ -51,3 +86,8 @@ func FetchAccountsByUserID(db *gorm.DB, userID uint) ([]Account, error) {
 	result := db.Where("user_id = ?", userID).Find(&accounts)
 	return accounts, result.Error
 }
+
+// UpdateAccount saves the editable settings of an account
+func UpdateAccount(db *gorm.DB, a *Account) error {
+	return db.Model(a).Select("Name", "Broker", "Type", "CostBasisMethod").Updates(a).Error
+}
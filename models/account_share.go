@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Roles a user can have on an account. The owner is Account.UserID, viewers and editors are
+// given access with an AccountShare.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+// AccountShare gives another user viewer or editor access to an account
+type AccountShare struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	AccountID uint   `gorm:"uniqueIndex:idx_account_share"`
+	UserID    uint   `gorm:"uniqueIndex:idx_account_share;index"`
+	User      User   `gorm:"foreignKey:UserID"`
+	Role      string `gorm:"size:10"`
+}
+
+// ValidShareRole reports whether an account can be shared with the role
+func ValidShareRole(role string) bool {
+	return role == RoleViewer || role == RoleEditor
+}
+
+// RoleAllows reports whether role gives at least the access of required
+func RoleAllows(role, required string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[required]
+}
+
+// AccountRole returns the role of the user on the account, gorm.ErrRecordNotFound when the user
+// has no access
+func AccountRole(db *gorm.DB, userID, accountID uint) (string, error) {
+	var account Account
+	if err := db.Select("id", "user_id").First(&account, accountID).Error; err != nil {
+		return "", err
+	}
+	if account.UserID == userID {
+		return RoleOwner, nil
+	}
+
+	var share AccountShare
+	if err := db.Where("account_id = ? AND user_id = ?", accountID, userID).First(&share).Error; err != nil {
+		return "", err
+	}
+	return share.Role, nil
+}
+
+// ShareAccount gives the user the role on the account, changing the role of an existing share
+func ShareAccount(db *gorm.DB, accountID, userID uint, role string) (*AccountShare, error) {
+	if !ValidShareRole(role) {
+		return nil, errors.New("invalid role")
+	}
+	share := &AccountShare{AccountID: accountID, UserID: userID, Role: role}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "account_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "updated_at"}),
+	}).Create(share).Error
+	if err != nil {
+		return nil, err
+	}
+	// The insert may have become an update, load the stored row
+	if err := db.Where("account_id = ? AND user_id = ?", accountID, userID).First(share).Error; err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// UnshareAccount removes the user's access to the account
+func UnshareAccount(db *gorm.DB, accountID, userID uint) error {
+	result := db.Where("account_id = ? AND user_id = ?", accountID, userID).Delete(&AccountShare{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// FetchAccountShares returns the shares of an account with their users
+func FetchAccountShares(db *gorm.DB, accountID uint) ([]AccountShare, error) {
+	var shares []AccountShare
+	err := db.Preload("User").Where("account_id = ?", accountID).Order("id").Find(&shares).Error
+	return shares, err
+}
+
+// FetchUserAccounts returns the accounts the user owns followed by the ones shared with them,
+// each with the user's Role
+func FetchUserAccounts(db *gorm.DB, userID uint) ([]Account, error) {
+	accounts, err := FetchAccountsByUserID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range accounts {
+		accounts[i].Role = RoleOwner
+	}
+
+	var shares []AccountShare
+	if err := db.Where("user_id = ?", userID).Order("account_id").Find(&shares).Error; err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		account, err := FindAccountByID(db, share.AccountID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		account.Role = share.Role
+		accounts = append(accounts, *account)
+	}
+	return accounts, nil
+}
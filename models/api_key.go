@@ -0,0 +1,114 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scopes of an API key, each one includes the ones before it
+const (
+	APIKeyScopeRead   = "read"
+	APIKeyScopeImport = "import"
+	APIKeyScopeFull   = "full"
+)
+
+// apiKeyPrefix marks API keys so they are easy to tell apart from access tokens
+const apiKeyPrefix = "spk_"
+
+// apiKeyUsageInterval limits how often LastUsedAt is written for a busy key
+const apiKeyUsageInterval = time.Minute
+
+// ErrInvalidAPIKey is returned for an API key that is unknown or revoked
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKey lets scripts call the API without logging in. Only the hash of the key is stored,
+// Prefix is kept to tell keys apart in a list.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uint       `gorm:"index" json:"-"`
+	Name       string     `gorm:"size:100" json:"name"`
+	Scope      string     `gorm:"size:20" json:"scope"`
+	Prefix     string     `gorm:"size:12" json:"prefix"`
+	KeyHash    string     `gorm:"size:64;uniqueIndex" json:"-"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// ValidAPIKeyScope reports whether the scope is one of the supported scopes
+func ValidAPIKeyScope(scope string) bool {
+	switch scope {
+	case APIKeyScopeRead, APIKeyScopeImport, APIKeyScopeFull:
+		return true
+	}
+	return false
+}
+
+// IsAPIKey reports whether a credential looks like an API key rather than a JWT
+func IsAPIKey(credential string) bool {
+	return len(credential) > len(apiKeyPrefix) && credential[:len(apiKeyPrefix)] == apiKeyPrefix
+}
+
+// CreateAPIKey creates a key for the user and returns it with the secret key, which is only
+// available now
+func CreateAPIKey(db *gorm.DB, userID uint, name, scope string) (*APIKey, string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	key := apiKeyPrefix + token
+	apiKey := &APIKey{
+		UserID:  userID,
+		Name:    name,
+		Scope:   scope,
+		Prefix:  key[:12],
+		KeyHash: hashToken(key),
+	}
+	if err := db.Create(apiKey).Error; err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+// FindActiveAPIKey returns the key that is not revoked and records that it was used
+func FindActiveAPIKey(db *gorm.DB, key string) (*APIKey, error) {
+	var apiKey APIKey
+	err := db.Where("key_hash = ? AND revoked_at IS NULL", hashToken(key)).First(&apiKey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyUsageInterval {
+		if err := db.Model(&apiKey).Update("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &apiKey, nil
+}
+
+// FetchAPIKeys returns every key of the user, revoked ones included
+func FetchAPIKeys(db *gorm.DB, userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	err := db.Where("user_id = ?", userID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey stops a key of the user from working
+func RevokeAPIKey(db *gorm.DB, userID, id uint) error {
+	result := db.Model(&APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidAPIKey
+	}
+	return nil
+}
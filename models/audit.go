@@ -0,0 +1,159 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Audited actions, the part before the dot is the kind of record EntityID refers to
+const (
+	AuditAccountCreate     = "account.create"
+	AuditAccountUpdate     = "account.update"
+	AuditAccountDelete     = "account.delete"
+	AuditAccountShare      = "account.share"
+	AuditAccountUnshare    = "account.unshare"
+	AuditTransactionCreate = "transaction.create"
+	AuditTransactionUpdate = "transaction.update"
+	AuditTransactionDelete = "transaction.delete"
+	AuditImportStart       = "import.start"
+	AuditImportFinish      = "import.finish"
+	AuditImportUndo        = "import.undo"
+	AuditBackupRestore     = "backup.restore"
+	AuditLogin             = "session.login"
+)
+
+// AuditValues is a JSON document stored as text, it is written out as JSON rather than a string
+type AuditValues string
+
+// MarshalJSON writes the stored document, or null when there is none
+func (v AuditValues) MarshalJSON() ([]byte, error) {
+	if v == "" {
+		return []byte("null"), nil
+	}
+	return []byte(v), nil
+}
+
+// UnmarshalJSON keeps the document as it is
+func (v *AuditValues) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*v = ""
+		return nil
+	}
+	*v = AuditValues(data)
+	return nil
+}
+
+// AuditEntry records who changed what and when, with the values before and after the change
+type AuditEntry struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID" json:"-"`
+	// APIKeyID is the key the change was made with, nil for a login session
+	APIKeyID  *uint       `json:"api_key_id"`
+	IP        string      `gorm:"size:45" json:"ip"`
+	Action    string      `gorm:"size:40;index" json:"action"`
+	AccountID *uint       `gorm:"index" json:"account_id"`
+	EntityID  uint        `json:"entity_id"`
+	Before    AuditValues `gorm:"type:text" json:"before"`
+	After     AuditValues `gorm:"type:text" json:"after"`
+}
+
+// RecordAudit stores the entry with before and after encoded as JSON, nil values are left empty
+func RecordAudit(db *gorm.DB, entry *AuditEntry, before, after interface{}) error {
+	var err error
+	if entry.Before, err = auditValues(before); err != nil {
+		return err
+	}
+	if entry.After, err = auditValues(after); err != nil {
+		return err
+	}
+	return db.Create(entry).Error
+}
+
+func auditValues(v interface{}) (AuditValues, error) {
+	if v == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return AuditValues(b), nil
+}
+
+// TransactionAuditValues are the fields of a transaction kept in the audit log
+func TransactionAuditValues(t *Transaction) map[string]interface{} {
+	return map[string]interface{}{
+		"date":          t.Date.Format("2006-01-02"),
+		"action":        t.Action,
+		"symbol":        t.Symbol,
+		"description":   t.Description,
+		"quantity":      t.Quantity,
+		"price":         t.Price,
+		"fees":          t.Fees,
+		"amount":        t.Amount,
+		"import_job_id": t.ImportJobID,
+	}
+}
+
+// AccountAuditValues are the fields of an account kept in the audit log
+func AccountAuditValues(a *Account) map[string]interface{} {
+	return map[string]interface{}{
+		"name":              a.Name,
+		"broker":            a.Broker,
+		"type":              a.Type,
+		"cost_basis_method": a.CostBasisMethod,
+	}
+}
+
+// ImportJobAuditValues are the fields of an import job kept in the audit log
+func ImportJobAuditValues(j *ImportJob) map[string]interface{} {
+	return map[string]interface{}{
+		"status":              j.Status,
+		"format":              j.Format,
+		"imported":            j.Imported,
+		"skipped_duplicate":   j.SkippedDuplicate,
+		"skipped_unsupported": j.SkippedUnsupported,
+		"failed":              j.Failed,
+		"error":               j.Error,
+	}
+}
+
+// AuditFilter narrows the audit log, zero values don't filter
+type AuditFilter struct {
+	AccountID uint
+	Action    string
+	From      time.Time
+	To        time.Time
+	// Before only returns entries older than this entry ID, for paging
+	Before uint
+}
+
+// FetchAuditEntries returns the changes the user made and the changes to accounts they can
+// view, newest first with the user who made them
+func FetchAuditEntries(db *gorm.DB, userID uint, filter AuditFilter, limit int) ([]AuditEntry, error) {
+	query := db.Preload("User").
+		Where("user_id = ? OR account_id IN (?)", userID, userAccountIDs(db, userID))
+	if filter.AccountID != 0 {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Before != 0 {
+		query = query.Where("id < ?", filter.Before)
+	}
+
+	var entries []AuditEntry
+	err := query.Order("id DESC").Limit(limit).Find(&entries).Error
+	return entries, err
+}
@@ -0,0 +1,49 @@
+package models
+
+import "gorm.io/gorm"
+
+// userAccountIDs selects the IDs of the accounts a user owns or that are shared with them
+func userAccountIDs(db *gorm.DB, userID uint) *gorm.DB {
+	newDB := db.Session(&gorm.Session{NewDB: true})
+	shared := newDB.Model(&AccountShare{}).Select("account_id").Where("user_id = ?", userID)
+	return newDB.Model(&Account{}).Select("id").Where("user_id = ? OR id IN (?)", userID, shared)
+}
+
+// UserAccounts scopes a query on a table with an account_id column to the accounts a user can
+// view
+func UserAccounts(userID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("account_id IN (?)", userAccountIDs(db, userID))
+	}
+}
+
+// FindUserAccount finds an account by ID only when the user owns it or it is shared with them,
+// Role is set to the user's role
+func FindUserAccount(db *gorm.DB, userID, accountID uint) (*Account, error) {
+	role, err := AccountRole(db, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	account, err := FindAccountByID(db, accountID)
+	if err != nil {
+		return nil, err
+	}
+	account.Role = role
+	return account, nil
+}
+
+// FindUserTransaction finds a transaction by ID only when the user can view its account,
+// Account.Role is set to the user's role
+func FindUserTransaction(db *gorm.DB, userID, id uint) (*Transaction, error) {
+	var transaction Transaction
+	result := db.Scopes(UserAccounts(userID)).Preload("Account").First(&transaction, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	role, err := AccountRole(db, userID, transaction.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	transaction.Account.Role = role
+	return &transaction, nil
+}
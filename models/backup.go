@@ -0,0 +1,293 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BackupVersion is the archive layout version written by BuildBackup
+const BackupVersion = 1
+
+// Backup is everything stored for a user. IDs in the archive are only used to link its
+// records together, RestoreBackup assigns new ones.
+type Backup struct {
+	Version      int                 `json:"version"`
+	CreatedAt    time.Time           `json:"created_at"`
+	Email        string              `json:"email"`
+	Accounts     []BackupAccount     `json:"accounts"`
+	Transactions []BackupTransaction `json:"transactions"`
+	ImportJobs   []BackupImportJob   `json:"import_jobs"`
+}
+
+type BackupAccount struct {
+	ID              uint    `json:"id"`
+	Name            string  `json:"name"`
+	Broker          string  `json:"broker,omitempty"`
+	Type            string  `json:"type,omitempty"`
+	CostBasisMethod string  `json:"cost_basis_method,omitempty"`
+	Balance         float64 `json:"balance"`
+}
+
+type BackupTransaction struct {
+	ID                    uint    `json:"id"`
+	AccountID             uint    `json:"account_id"`
+	Date                  string  `json:"date"`
+	Action                string  `json:"action"`
+	Symbol                string  `json:"symbol"`
+	Description           string  `json:"description"`
+	Quantity              float64 `json:"quantity"`
+	Price                 float64 `json:"price"`
+	Fees                  float64 `json:"fees"`
+	Amount                float64 `json:"amount"`
+	Processed             bool    `json:"processed"`
+	DividendTransactionID *uint   `json:"dividend_transaction_id,omitempty"`
+	ImportJobID           *uint   `json:"import_job_id,omitempty"`
+	Fingerprint           string  `json:"fingerprint,omitempty"`
+}
+
+type BackupImportJob struct {
+	ID                 uint              `json:"id"`
+	AccountID          uint              `json:"account_id"`
+	CreatedAt          time.Time         `json:"created_at"`
+	Status             string            `json:"status"`
+	Format             string            `json:"format"`
+	Imported           int               `json:"imported"`
+	SkippedDuplicate   int               `json:"skipped_duplicate"`
+	SkippedUnsupported int               `json:"skipped_unsupported"`
+	Failed             int               `json:"failed"`
+	Error              string            `json:"error,omitempty"`
+	StartedAt          *time.Time        `json:"started_at,omitempty"`
+	FinishedAt         *time.Time        `json:"finished_at,omitempty"`
+	Rows               []BackupImportRow `json:"rows,omitempty"`
+}
+
+type BackupImportRow struct {
+	File    string `json:"file"`
+	Ordinal int    `json:"ordinal"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+}
+
+// BuildBackup collects the accounts, transactions and import history of a user. Positions are
+// left out since they are generated from the transactions.
+func BuildBackup(db *gorm.DB, userID uint) (*Backup, error) {
+	user, err := FindUserByID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	backup := &Backup{Version: BackupVersion, CreatedAt: time.Now(), Email: user.Email}
+
+	accounts, err := FetchAccountsByUserID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	accountIDs := make([]uint, len(accounts))
+	for i, a := range accounts {
+		accountIDs[i] = a.ID
+		backup.Accounts = append(backup.Accounts, BackupAccount{
+			ID:              a.ID,
+			Name:            a.Name,
+			Broker:          a.Broker,
+			Type:            a.Type,
+			CostBasisMethod: a.CostBasisMethod,
+			Balance:         a.Balance,
+		})
+	}
+	if len(accountIDs) == 0 {
+		return backup, nil
+	}
+
+	var transactions []Transaction
+	if err := db.Where("account_id IN ?", accountIDs).Order("account_id ASC, date ASC, id ASC").Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range transactions {
+		backup.Transactions = append(backup.Transactions, BackupTransaction{
+			ID:                    t.ID,
+			AccountID:             t.AccountID,
+			Date:                  t.Date.Format("2006-01-02"),
+			Action:                t.Action,
+			Symbol:                t.Symbol,
+			Description:           t.Description,
+			Quantity:              t.Quantity,
+			Price:                 t.Price,
+			Fees:                  t.Fees,
+			Amount:                t.Amount,
+			Processed:             t.Processed,
+			DividendTransactionID: t.DividendTransactionID,
+			ImportJobID:           t.ImportJobID,
+			Fingerprint:           t.Fingerprint,
+		})
+	}
+
+	var jobs []ImportJob
+	err = db.Preload("Rows", func(db *gorm.DB) *gorm.DB {
+		return db.Order("file ASC, ordinal ASC")
+	}).Where("account_id IN ?", accountIDs).Order("id ASC").Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		rows := make([]BackupImportRow, len(j.Rows))
+		for i, row := range j.Rows {
+			rows[i] = BackupImportRow{File: row.File, Ordinal: row.Ordinal, Status: row.Status, Reason: row.Reason}
+		}
+		backup.ImportJobs = append(backup.ImportJobs, BackupImportJob{
+			ID:                 j.ID,
+			AccountID:          j.AccountID,
+			CreatedAt:          j.CreatedAt,
+			Status:             j.Status,
+			Format:             j.Format,
+			Imported:           j.Imported,
+			SkippedDuplicate:   j.SkippedDuplicate,
+			SkippedUnsupported: j.SkippedUnsupported,
+			Failed:             j.Failed,
+			Error:              j.Error,
+			StartedAt:          j.StartedAt,
+			FinishedAt:         j.FinishedAt,
+			Rows:               rows,
+		})
+	}
+	return backup, nil
+}
+
+// InvalidBackupError is returned when the contents of a backup archive can't be restored
+type InvalidBackupError struct {
+	Reason string
+}
+
+func (e *InvalidBackupError) Error() string {
+	return e.Reason
+}
+
+// RestoreBackup adds the accounts of a backup to a user in a single transaction, giving every
+// account, transaction and import job a new ID. It returns the IDs of the new accounts, their
+// positions need to be generated afterwards. Problems with the archive itself are returned as
+// an *InvalidBackupError.
+func RestoreBackup(db *gorm.DB, userID uint, b *Backup) ([]uint, error) {
+	if b.Version != BackupVersion {
+		return nil, &InvalidBackupError{fmt.Sprintf("unsupported backup version %d", b.Version)}
+	}
+
+	var accountIDs []uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		accounts := make(map[uint]uint)
+		for _, a := range b.Accounts {
+			account := Account{
+				UserID:          userID,
+				Name:            a.Name,
+				Broker:          a.Broker,
+				Type:            a.Type,
+				CostBasisMethod: a.CostBasisMethod,
+				Balance:         a.Balance,
+			}
+			if err := tx.Create(&account).Error; err != nil {
+				return err
+			}
+			accounts[a.ID] = account.ID
+			accountIDs = append(accountIDs, account.ID)
+		}
+
+		jobs := make(map[uint]uint)
+		for _, j := range b.ImportJobs {
+			accountID, ok := accounts[j.AccountID]
+			if !ok {
+				return &InvalidBackupError{fmt.Sprintf("import job %d belongs to unknown account %d", j.ID, j.AccountID)}
+			}
+			job := ImportJob{
+				UserID:             userID,
+				AccountID:          accountID,
+				Status:             j.Status,
+				Format:             j.Format,
+				Imported:           j.Imported,
+				SkippedDuplicate:   j.SkippedDuplicate,
+				SkippedUnsupported: j.SkippedUnsupported,
+				Failed:             j.Failed,
+				Error:              j.Error,
+				StartedAt:          j.StartedAt,
+				FinishedAt:         j.FinishedAt,
+			}
+			job.CreatedAt = j.CreatedAt
+			// A job that was still in progress will never finish in the new environment
+			if job.Status == ImportQueued || job.Status == ImportRunning {
+				job.Status = ImportFailed
+				job.Error = "interrupted by backup"
+			}
+			if err := tx.Create(&job).Error; err != nil {
+				return err
+			}
+			jobs[j.ID] = job.ID
+
+			rows := make([]ImportRowResult, len(j.Rows))
+			for i, row := range j.Rows {
+				rows[i] = ImportRowResult{ImportJobID: job.ID, File: row.File, Ordinal: row.Ordinal, Status: row.Status, Reason: row.Reason}
+			}
+			if len(rows) > 0 {
+				if err := tx.CreateInBatches(rows, 500).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		transactions := make([]Transaction, len(b.Transactions))
+		for i, bt := range b.Transactions {
+			accountID, ok := accounts[bt.AccountID]
+			if !ok {
+				return &InvalidBackupError{fmt.Sprintf("transaction %d belongs to unknown account %d", bt.ID, bt.AccountID)}
+			}
+			date, err := time.ParseInLocation("2006-01-02", bt.Date, time.Local)
+			if err != nil {
+				return &InvalidBackupError{fmt.Sprintf("transaction %d has an invalid date %q", bt.ID, bt.Date)}
+			}
+			transactions[i] = Transaction{
+				AccountID:   accountID,
+				Date:        date,
+				Action:      bt.Action,
+				Symbol:      bt.Symbol,
+				Description: bt.Description,
+				Quantity:    bt.Quantity,
+				Price:       bt.Price,
+				Fees:        bt.Fees,
+				Amount:      bt.Amount,
+				Processed:   bt.Processed,
+				Fingerprint: bt.Fingerprint,
+			}
+			if bt.ImportJobID != nil {
+				if jobID, ok := jobs[*bt.ImportJobID]; ok {
+					transactions[i].ImportJobID = &jobID
+				}
+			}
+		}
+		if len(transactions) == 0 {
+			return nil
+		}
+		if err := tx.CreateInBatches(transactions, 500).Error; err != nil {
+			return err
+		}
+
+		// Dividend links point at other transactions, so they are set once every transaction has its new ID
+		ids := make(map[uint]uint)
+		for i, bt := range b.Transactions {
+			ids[bt.ID] = transactions[i].ID
+		}
+		for i, bt := range b.Transactions {
+			if bt.DividendTransactionID == nil {
+				continue
+			}
+			dividendID, ok := ids[*bt.DividendTransactionID]
+			if !ok {
+				continue
+			}
+			if err := tx.Model(&transactions[i]).Update("dividend_transaction_id", dividendID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accountIDs, nil
+}
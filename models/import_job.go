@@ -0,0 +1,143 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Import job states
+const (
+	ImportQueued    = "queued"
+	ImportRunning   = "running"
+	ImportSucceeded = "succeeded"
+	ImportFailed    = "failed"
+	ImportUndone    = "undone"
+)
+
+// Row outcomes recorded on an import job
+const (
+	RowImported           = "imported"
+	RowSkippedDuplicate   = "skipped-duplicate"
+	RowSkippedUnsupported = "skipped-unsupported"
+	RowFailed             = "failed"
+)
+
+// ImportJob tracks a single upload through the import and the outcome of its rows
+type ImportJob struct {
+	gorm.Model
+	ID                 uint   `gorm:"primaryKey"`
+	UserID             uint   `gorm:"index"`
+	AccountID          uint   `gorm:"index"`
+	Status             string `gorm:"size:20"`
+	Format             string `gorm:"size:50"`
+	Imported           int
+	SkippedDuplicate   int
+	SkippedUnsupported int
+	Failed             int
+	Error              string `gorm:"size:1000"`
+	StartedAt          *time.Time
+	FinishedAt         *time.Time
+	Rows               []ImportRowResult `gorm:"foreignKey:ImportJobID"`
+}
+
+// ImportRowResult is the reason a source row was not imported
+type ImportRowResult struct {
+	ID          uint   `gorm:"primaryKey"`
+	ImportJobID uint   `gorm:"index"`
+	File        string `gorm:"size:255"`
+	Ordinal     int
+	Status      string `gorm:"size:30"`
+	Reason      string `gorm:"size:500"`
+}
+
+// CreateImportJob creates a queued import job for an account
+func CreateImportJob(db *gorm.DB, userID, accountID uint, format string) (*ImportJob, error) {
+	job := &ImportJob{
+		UserID:    userID,
+		AccountID: accountID,
+		Status:    ImportQueued,
+		Format:    format,
+	}
+	if err := db.Create(job).Error; err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// FindImportJobByID fetches an import job with its row results
+func FindImportJobByID(db *gorm.DB, id uint) (*ImportJob, error) {
+	var job ImportJob
+	result := db.Preload("Rows", func(db *gorm.DB) *gorm.DB {
+		return db.Order("file ASC, ordinal ASC")
+	}).First(&job, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &job, nil
+}
+
+// Start marks the job as running
+func (j *ImportJob) Start(db *gorm.DB) error {
+	now := time.Now()
+	j.Status = ImportRunning
+	j.StartedAt = &now
+	return db.Model(j).Select("Status", "StartedAt").Updates(j).Error
+}
+
+// RecordRow counts a row outcome, rows that were not imported keep their reason
+func (j *ImportJob) RecordRow(file string, ordinal int, status, reason string) {
+	switch status {
+	case RowImported:
+		j.Imported++
+		return
+	case RowSkippedDuplicate:
+		j.SkippedDuplicate++
+	case RowSkippedUnsupported:
+		j.SkippedUnsupported++
+	case RowFailed:
+		j.Failed++
+	}
+	j.Rows = append(j.Rows, ImportRowResult{
+		ImportJobID: j.ID,
+		File:        file,
+		Ordinal:     ordinal,
+		Status:      status,
+		Reason:      reason,
+	})
+}
+
+// Finish saves the counts and row results and marks the job as succeeded, or failed when err is set
+func (j *ImportJob) Finish(db *gorm.DB, err error) error {
+	now := time.Now()
+	j.FinishedAt = &now
+	j.Status = ImportSucceeded
+	if err != nil {
+		j.Status = ImportFailed
+		j.Error = err.Error()
+	}
+
+	if len(j.Rows) > 0 {
+		if err := db.CreateInBatches(j.Rows, 500).Error; err != nil {
+			return err
+		}
+	}
+	return db.Model(j).Select("Status", "Imported", "SkippedDuplicate", "SkippedUnsupported", "Failed", "Error", "FinishedAt").Updates(j).Error
+}
+
+// UndoImportJob deletes the transactions the job created and marks the job as undone.
+// Positions for the account need to be regenerated afterwards.
+func UndoImportJob(db *gorm.DB, j *ImportJob) (int64, error) {
+	var deleted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("import_job_id = ? AND account_id = ?", j.ID, j.AccountID).Delete(&Transaction{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+
+		j.Status = ImportUndone
+		return tx.Model(j).Select("Status").Updates(j).Error
+	})
+	return deleted, err
+}
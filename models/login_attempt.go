@@ -0,0 +1,124 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Outcomes of a login attempt
+const (
+	LoginSucceeded    = "succeeded"
+	LoginUnknownEmail = "unknown_email"
+	LoginBadPassword  = "bad_password"
+	LoginBadCode      = "bad_code"
+	// LoginThrottled attempts were refused without checking the password, they are kept for
+	// the audit but don't extend the delay
+	LoginThrottled = "throttled"
+)
+
+// LoginAttempt records a login for throttling and so users can see failed logins on their account
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	Email     string    `gorm:"size:255;index" json:"email"`
+	IP        string    `gorm:"size:45;index" json:"ip"`
+	UserID    *uint     `gorm:"index" json:"-"`
+	Result    string    `gorm:"size:20" json:"result"`
+}
+
+// LoginPolicy decides how long failed logins are delayed. After MaxAttempts failures for an
+// email, or MaxAttemptsPerIP for an IP address, the next attempt has to wait BaseDelay, doubling
+// with each failure up to Lockout. Failures older than Lockout are forgotten and a successful
+// login resets the count for the email.
+type LoginPolicy struct {
+	MaxAttempts      int
+	MaxAttemptsPerIP int
+	BaseDelay        time.Duration
+	Lockout          time.Duration
+}
+
+// NormalizeLoginEmail is the email attempts are tracked by, so changing its case doesn't get
+// around the limit
+func NormalizeLoginEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// RecordLoginAttempt stores the outcome of a login, userID is nil for an unknown email
+func RecordLoginAttempt(db *gorm.DB, email, ip string, userID *uint, result string) error {
+	return db.Create(&LoginAttempt{
+		Email:  NormalizeLoginEmail(email),
+		IP:     ip,
+		UserID: userID,
+		Result: result,
+	}).Error
+}
+
+// RetryAfter returns how long the email and IP address have to wait before the next login
+// attempt, zero when they can try now
+func (p LoginPolicy) RetryAfter(db *gorm.DB, email, ip string, now time.Time) (time.Duration, error) {
+	since := now.Add(-p.Lockout)
+
+	// A successful login for the email starts its count over
+	var lastSuccess LoginAttempt
+	err := db.Where("email = ? AND result = ? AND created_at > ?", NormalizeLoginEmail(email), LoginSucceeded, since).
+		Order("created_at DESC").Limit(1).Find(&lastSuccess).Error
+	if err != nil {
+		return 0, err
+	}
+	emailSince := since
+	if lastSuccess.ID != 0 {
+		emailSince = lastSuccess.CreatedAt
+	}
+
+	emailWait, err := p.wait(db, "email", NormalizeLoginEmail(email), emailSince, p.MaxAttempts, now)
+	if err != nil {
+		return 0, err
+	}
+	ipWait, err := p.wait(db, "ip", ip, since, p.MaxAttemptsPerIP, now)
+	if err != nil {
+		return 0, err
+	}
+	if ipWait > emailWait {
+		return ipWait, nil
+	}
+	return emailWait, nil
+}
+
+// wait is the remaining delay after the failures for the email or ip column since the given time
+func (p LoginPolicy) wait(db *gorm.DB, column, value string, since time.Time, maxAttempts int, now time.Time) (time.Duration, error) {
+	failures := func() *gorm.DB {
+		return db.Model(&LoginAttempt{}).
+			Where(column+" = ? AND created_at > ?", value, since).
+			Where("result NOT IN ?", []string{LoginSucceeded, LoginThrottled})
+	}
+
+	var count int64
+	if err := failures().Count(&count).Error; err != nil || count < int64(maxAttempts) {
+		return 0, err
+	}
+	var last LoginAttempt
+	if err := failures().Order("created_at DESC").First(&last).Error; err != nil {
+		return 0, err
+	}
+
+	delay := p.Lockout
+	if shift := count - int64(maxAttempts); shift < 32 {
+		if d := p.BaseDelay << shift; d < p.Lockout {
+			delay = d
+		}
+	}
+	if remaining := last.CreatedAt.Add(delay).Sub(now); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+// FetchFailedLogins returns the most recent failed logins to the user's account, newest first
+func FetchFailedLogins(db *gorm.DB, userID uint, limit int) ([]LoginAttempt, error) {
+	var attempts []LoginAttempt
+	err := db.Where("user_id = ? AND result <> ?", userID, LoginSucceeded).
+		Order("created_at DESC, id DESC").Limit(limit).Find(&attempts).Error
+	return attempts, err
+}
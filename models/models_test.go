@@ -1,6 +1,9 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,7 +17,7 @@ func setupDB() (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	db.AutoMigrate(&Transaction{}, &User{}, &Position{}, &Account{}, &StockSplit{})
+	db.AutoMigrate(&Transaction{}, &User{}, &Position{}, &Account{}, &StockSplit{}, &ImportJob{}, &ImportRowResult{}, &Session{}, &RefreshToken{}, &UserToken{}, &RecoveryCode{}, &APIKey{}, &AccountShare{}, &LoginAttempt{}, &AuditEntry{})
 	return db, nil
 }
 
@@ -602,3 +605,798 @@ func TestGMEOptionsTransactions(t *testing.T) {
 		})
 	})
 }
+
+func TestReinvestShares(t *testing.T) {
+	Convey("Given a stock position and a dividend that is reinvested", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+
+		account := Account{ID: 1, Name: "Test Account", UserID: 1}
+		db.Create(&account)
+
+		buyDate := time.Date(2023, 1, 10, 0, 0, 0, 0, time.UTC)
+		dripDate := time.Date(2023, 3, 15, 0, 0, 0, 0, time.UTC)
+
+		transactions := []Transaction{
+			{
+				Date:      buyDate,
+				Action:    "Buy",
+				Symbol:    "KO",
+				Quantity:  100,
+				Price:     60,
+				Amount:    -6000,
+				AccountID: account.ID,
+			},
+			{
+				Date:      dripDate,
+				Action:    "Reinvest Dividend",
+				Symbol:    "KO",
+				Amount:    46,
+				AccountID: account.ID,
+			},
+			{
+				Date:      dripDate,
+				Action:    "Reinvest Shares",
+				Symbol:    "KO",
+				Quantity:  0.7667,
+				Price:     60,
+				Amount:    -46,
+				AccountID: account.ID,
+			},
+		}
+		err = CreateMany(db, transactions)
+		So(err, ShouldBeNil)
+
+		Convey("When positions are generated", func() {
+			err := GeneratePositions(db, account.ID)
+			So(err, ShouldBeNil)
+
+			Convey("The reinvest lot should be linked to the dividend cash row", func() {
+				var reinvest Transaction
+				db.Where("action = ?", "Reinvest Shares").First(&reinvest)
+				So(reinvest.DividendTransactionID, ShouldNotBeNil)
+				So(*reinvest.DividendTransactionID, ShouldEqual, transactions[1].ID)
+			})
+
+			Convey("The reinvested shares should be counted in quantity and cost basis", func() {
+				var position Position
+				db.Where("symbol = ?", "KO").First(&position)
+				So(position.Quantity, ShouldAlmostEqual, 100.7667, 0.0001)
+				So(position.CostBasis, ShouldAlmostEqual, 60, 0.0001)
+				So(position.Opened, ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestOptionPremiumReport(t *testing.T) {
+	Convey("Given covered calls, cash-secured puts and a spread", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+
+		account := Account{ID: 1, Name: "Test Account", UserID: 1}
+		db.Create(&account)
+
+		jan := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+		feb := time.Date(2024, 2, 9, 0, 0, 0, 0, time.UTC)
+
+		transactions := []Transaction{
+			{Date: jan, Action: "Buy", Symbol: "AAPL", Quantity: 100, Price: 180, Amount: -18000, AccountID: account.ID},
+			{Date: jan, Action: "Sell to Open", Symbol: "AAPL 02/16/2024 200.00 C", Quantity: 1, Price: 2.10, Amount: 209.34, AccountID: account.ID},
+			{Date: jan, Action: "Sell to Open", Symbol: "MSFT 02/16/2024 350.00 P", Quantity: 1, Price: 4.00, Amount: 399.34, AccountID: account.ID},
+			{Date: jan, Action: "Sell to Open", Symbol: "SPY 02/16/2024 460.00 P", Quantity: 1, Price: 3.00, Amount: 299.34, AccountID: account.ID},
+			{Date: jan, Action: "Buy to Open", Symbol: "SPY 02/16/2024 450.00 P", Quantity: 1, Price: 1.50, Amount: -150.66, AccountID: account.ID},
+			{Date: feb, Action: "Buy to Close", Symbol: "AAPL 02/16/2024 200.00 C", Quantity: 1, Price: 0.50, Amount: -50.66, AccountID: account.ID},
+		}
+		err = CreateMany(db, transactions)
+		So(err, ShouldBeNil)
+
+		Convey("When the report is generated", func() {
+			report, err := OptionPremiumReport(db, account.ID)
+			So(err, ShouldBeNil)
+			So(report, ShouldHaveLength, 4)
+
+			Convey("Rows should be grouped by month, underlying and strategy", func() {
+				So(report[0].Month, ShouldEqual, "2024-01")
+				So(report[0].Underlying, ShouldEqual, "AAPL")
+				So(report[0].Strategy, ShouldEqual, StrategyCoveredCall)
+				So(report[0].Collected, ShouldAlmostEqual, 209.34, 0.001)
+
+				So(report[1].Underlying, ShouldEqual, "MSFT")
+				So(report[1].Strategy, ShouldEqual, StrategyCashSecuredPut)
+
+				So(report[2].Underlying, ShouldEqual, "SPY")
+				So(report[2].Strategy, ShouldEqual, StrategySpread)
+				So(report[2].Net, ShouldAlmostEqual, 148.68, 0.001)
+
+				So(report[3].Month, ShouldEqual, "2024-02")
+				So(report[3].Strategy, ShouldEqual, StrategyCoveredCall)
+				So(report[3].Paid, ShouldAlmostEqual, 50.66, 0.001)
+				So(report[3].Net, ShouldAlmostEqual, -50.66, 0.001)
+			})
+		})
+	})
+}
+
+func TestOptionPremiumReportReopenedContract(t *testing.T) {
+	Convey("Given the same call opened uncovered, then covered after buying shares, then uncovered again", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+
+		account := Account{ID: 1, Name: "Test Account", UserID: 1}
+		db.Create(&account)
+
+		day := func(month time.Month, d int) time.Time { return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC) }
+		call := "AAPL 06/21/2024 200.00 C"
+		transactions := []Transaction{
+			{Date: day(1, 5), Action: "Sell to Open", Symbol: call, Quantity: 1, Amount: 300, AccountID: account.ID},
+			{Date: day(2, 5), Action: "Buy to Close", Symbol: call, Quantity: 1, Amount: -100, AccountID: account.ID},
+			{Date: day(3, 1), Action: "Buy", Symbol: "AAPL", Quantity: 100, Price: 180, Amount: -18000, AccountID: account.ID},
+			{Date: day(3, 5), Action: "Sell to Open", Symbol: call, Quantity: 1, Amount: 250, AccountID: account.ID},
+			{Date: day(4, 5), Action: "Buy to Close", Symbol: call, Quantity: 1, Amount: -50, AccountID: account.ID},
+			{Date: day(5, 1), Action: "Sell", Symbol: "AAPL", Quantity: 100, Price: 190, Amount: 19000, AccountID: account.ID},
+			{Date: day(5, 5), Action: "Sell to Open", Symbol: call, Quantity: 1, Amount: 200, AccountID: account.ID},
+		}
+		So(CreateMany(db, transactions), ShouldBeNil)
+
+		report, err := OptionPremiumReport(db, account.ID)
+		So(err, ShouldBeNil)
+		So(report, ShouldHaveLength, 5)
+
+		Convey("Each opening should keep its own strategy and its close should follow it", func() {
+			strategies := map[string]string{}
+			for _, row := range report {
+				strategies[row.Month] = row.Strategy
+			}
+			So(strategies, ShouldResemble, map[string]string{
+				"2024-01": StrategyUncoveredCall,
+				"2024-02": StrategyUncoveredCall,
+				"2024-03": StrategyCoveredCall,
+				"2024-04": StrategyCoveredCall,
+				"2024-05": StrategyUncoveredCall,
+			})
+		})
+	})
+
+	Convey("Given a close that spans two lots opened under different share positions", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+
+		account := Account{ID: 1, Name: "Test Account", UserID: 1}
+		db.Create(&account)
+
+		call := "AAPL 06/21/2024 200.00 C"
+		jan := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+		feb := time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)
+		mar := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+		transactions := []Transaction{
+			{Date: jan, Action: "Sell to Open", Symbol: call, Quantity: 1, Amount: 300, AccountID: account.ID},
+			{Date: feb, Action: "Buy", Symbol: "AAPL", Quantity: 100, Price: 180, Amount: -18000, AccountID: account.ID},
+			{Date: feb, Action: "Sell to Open", Symbol: call, Quantity: 1, Amount: 300, AccountID: account.ID},
+			{Date: mar, Action: "Buy to Close", Symbol: call, Quantity: 2, Amount: -100, AccountID: account.ID},
+		}
+		So(CreateMany(db, transactions), ShouldBeNil)
+
+		report, err := OptionPremiumReport(db, account.ID)
+		So(err, ShouldBeNil)
+
+		Convey("The premium paid should be split between the strategies by quantity", func() {
+			paid := map[string]float64{}
+			for _, row := range report {
+				if row.Month == "2024-03" {
+					paid[row.Strategy] = row.Paid
+				}
+			}
+			So(paid[StrategyUncoveredCall], ShouldAlmostEqual, 50, 0.001)
+			So(paid[StrategyCoveredCall], ShouldAlmostEqual, 50, 0.001)
+		})
+	})
+}
+
+func TestOptionPremiumReportCoveredShares(t *testing.T) {
+	Convey("Given more short calls than blocks of 100 shares", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+
+		account := Account{ID: 1, Name: "Test Account", UserID: 1}
+		db.Create(&account)
+
+		day := func(month time.Month, d int) time.Time { return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC) }
+		june := "AAPL 06/21/2024 200.00 C"
+		july := "AAPL 07/19/2024 210.00 C"
+		transactions := []Transaction{
+			{Date: day(1, 2), Action: "Buy", Symbol: "AAPL", Quantity: 150, Price: 180, Amount: -27000, AccountID: account.ID},
+			{Date: day(1, 5), Action: "Sell to Open", Symbol: june, Quantity: 1, Amount: 300, AccountID: account.ID},
+			{Date: day(1, 8), Action: "Sell to Open", Symbol: july, Quantity: 1, Amount: 200, AccountID: account.ID},
+			{Date: day(2, 1), Action: "Buy", Symbol: "AAPL", Quantity: 50, Price: 185, Amount: -9250, AccountID: account.ID},
+			{Date: day(2, 5), Action: "Sell to Open", Symbol: june, Quantity: 2, Amount: 400, AccountID: account.ID},
+			{Date: day(3, 5), Action: "Buy to Close", Symbol: june, Quantity: 1, Amount: -100, AccountID: account.ID},
+			{Date: day(4, 5), Action: "Sell to Open", Symbol: july, Quantity: 1, Amount: 150, AccountID: account.ID},
+		}
+		So(CreateMany(db, transactions), ShouldBeNil)
+
+		report, err := OptionPremiumReport(db, account.ID)
+		So(err, ShouldBeNil)
+		totals := map[[2]string]OptionPremiumIncome{}
+		for _, row := range report {
+			totals[[2]string{row.Month, row.Strategy}] = row
+		}
+
+		Convey("Shares covering an open call should not cover another one", func() {
+			So(totals[[2]string{"2024-01", StrategyCoveredCall}].Collected, ShouldAlmostEqual, 300, 0.001)
+			So(totals[[2]string{"2024-01", StrategyUncoveredCall}].Collected, ShouldAlmostEqual, 200, 0.001)
+		})
+
+		Convey("A sale of several contracts should be split by the shares left to cover them", func() {
+			So(totals[[2]string{"2024-02", StrategyCoveredCall}].Collected, ShouldAlmostEqual, 200, 0.001)
+			So(totals[[2]string{"2024-02", StrategyUncoveredCall}].Collected, ShouldAlmostEqual, 200, 0.001)
+		})
+
+		Convey("Closing a covered call should free its shares for the next call", func() {
+			So(totals[[2]string{"2024-03", StrategyCoveredCall}].Paid, ShouldAlmostEqual, 100, 0.001)
+			So(totals[[2]string{"2024-04", StrategyCoveredCall}].Collected, ShouldAlmostEqual, 150, 0.001)
+			So(totals, ShouldNotContainKey, [2]string{"2024-04", StrategyUncoveredCall})
+		})
+	})
+}
+
+func TestImportJob(t *testing.T) {
+	Convey("Given a queued import job", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+
+		job, err := CreateImportJob(db, 1, 1, "schwab-json")
+		So(err, ShouldBeNil)
+		So(job.Status, ShouldEqual, ImportQueued)
+
+		Convey("When the import runs and records row outcomes", func() {
+			So(job.Start(db), ShouldBeNil)
+			job.RecordRow("a.json", 1, RowImported, "")
+			job.RecordRow("a.json", 2, RowImported, "")
+			job.RecordRow("a.json", 3, RowSkippedDuplicate, "matches transaction 7")
+			job.RecordRow("a.json", 4, RowSkippedUnsupported, `unsupported action "Journal"`)
+			job.RecordRow("a.json", 5, RowFailed, `invalid date "yesterday"`)
+			So(job.Finish(db, nil), ShouldBeNil)
+
+			Convey("Then the counts and reasons should be stored", func() {
+				stored, err := FindImportJobByID(db, job.ID)
+				So(err, ShouldBeNil)
+				So(stored.Status, ShouldEqual, ImportSucceeded)
+				So(stored.StartedAt, ShouldNotBeNil)
+				So(stored.FinishedAt, ShouldNotBeNil)
+				So(stored.Imported, ShouldEqual, 2)
+				So(stored.SkippedDuplicate, ShouldEqual, 1)
+				So(stored.SkippedUnsupported, ShouldEqual, 1)
+				So(stored.Failed, ShouldEqual, 1)
+				So(stored.Rows, ShouldHaveLength, 3)
+				So(stored.Rows[2].Ordinal, ShouldEqual, 5)
+				So(stored.Rows[2].Reason, ShouldEqual, `invalid date "yesterday"`)
+			})
+		})
+
+		Convey("When the import fails", func() {
+			So(job.Finish(db, fmt.Errorf("unrecognized import file format")), ShouldBeNil)
+
+			Convey("Then the job should be failed with the error", func() {
+				stored, err := FindImportJobByID(db, job.ID)
+				So(err, ShouldBeNil)
+				So(stored.Status, ShouldEqual, ImportFailed)
+				So(stored.Error, ShouldEqual, "unrecognized import file format")
+			})
+		})
+	})
+}
+
+func TestUndoImportJob(t *testing.T) {
+	Convey("Given transactions created by an import and a manual transaction", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+
+		account := Account{ID: 1, Name: "Test Account", UserID: 1}
+		db.Create(&account)
+
+		job, err := CreateImportJob(db, 1, account.ID, "schwab-json")
+		So(err, ShouldBeNil)
+		So(job.Finish(db, nil), ShouldBeNil)
+
+		date := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+		transactions := []Transaction{
+			{Date: date, Action: "Buy", Symbol: "AAPL", Quantity: 100, Price: 180, Amount: -18000, AccountID: account.ID},
+			{Date: date, Action: "Buy", Symbol: "MSFT", Quantity: 10, Price: 350, Amount: -3500, AccountID: account.ID, ImportJobID: &job.ID},
+			{Date: date, Action: "Buy", Symbol: "MSFT", Quantity: 10, Price: 350, Amount: -3500, AccountID: account.ID, ImportJobID: &job.ID},
+		}
+		So(CreateMany(db, transactions), ShouldBeNil)
+		So(GeneratePositions(db, account.ID), ShouldBeNil)
+
+		Convey("When the import is undone", func() {
+			stored, err := FindImportJobByID(db, job.ID)
+			So(err, ShouldBeNil)
+			deleted, err := UndoImportJob(db, stored)
+			So(err, ShouldBeNil)
+			So(deleted, ShouldEqual, 2)
+			So(GeneratePositions(db, account.ID), ShouldBeNil)
+
+			Convey("Then only the imported transactions and their positions should be removed", func() {
+				var remaining []Transaction
+				db.Where("account_id = ?", account.ID).Find(&remaining)
+				So(remaining, ShouldHaveLength, 1)
+				So(remaining[0].Symbol, ShouldEqual, "AAPL")
+
+				positions, err := FetchPositionsByAccount(db, account.ID)
+				So(err, ShouldBeNil)
+				So(positions, ShouldHaveLength, 1)
+
+				stored, err := FindImportJobByID(db, job.ID)
+				So(err, ShouldBeNil)
+				So(stored.Status, ShouldEqual, ImportUndone)
+			})
+		})
+	})
+}
+
+func TestBackupRestore(t *testing.T) {
+	Convey("Given a user with an imported account and a reinvested dividend", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+
+		source := User{Email: "source@example.com", PasswordHash: "hashedpassword"}
+		db.Create(&source)
+		target := User{Email: "target@example.com", PasswordHash: "hashedpassword"}
+		db.Create(&target)
+		// An unrelated account so the restored IDs differ from the archived ones
+		db.Create(&Account{Name: "Other Account", UserID: target.ID})
+
+		account := Account{Name: "Brokerage", UserID: source.ID, Broker: "Schwab", Type: AccountRoth, Balance: 100}
+		db.Create(&account)
+		job, err := CreateImportJob(db, source.ID, account.ID, "schwab-json")
+		So(err, ShouldBeNil)
+		job.RecordRow("schwab.json", 3, RowSkippedUnsupported, `unsupported action "Journal"`)
+		So(job.Finish(db, nil), ShouldBeNil)
+
+		date := time.Date(2024, 3, 15, 0, 0, 0, 0, time.Local)
+		transactions := []Transaction{
+			{Date: date, Action: "Buy", Symbol: "KO", Quantity: 100, Price: 60, Amount: -6000, AccountID: account.ID, ImportJobID: &job.ID, Fingerprint: "buy"},
+			{Date: date, Action: "Reinvest Dividend", Symbol: "KO", Amount: 48.5, AccountID: account.ID},
+			{Date: date, Action: "Reinvest Shares", Symbol: "KO", Quantity: 0.8, Price: 60.63, Amount: -48.5, AccountID: account.ID},
+		}
+		So(CreateMany(db, transactions), ShouldBeNil)
+		So(GeneratePositions(db, account.ID), ShouldBeNil)
+
+		Convey("When the backup is restored into another user", func() {
+			backup, err := BuildBackup(db, source.ID)
+			So(err, ShouldBeNil)
+			So(backup.Accounts, ShouldHaveLength, 1)
+			So(backup.Transactions, ShouldHaveLength, 3)
+			So(backup.ImportJobs, ShouldHaveLength, 1)
+
+			accountIDs, err := RestoreBackup(db, target.ID, backup)
+			So(err, ShouldBeNil)
+			So(accountIDs, ShouldHaveLength, 1)
+			So(accountIDs[0], ShouldNotEqual, account.ID)
+			So(GeneratePositions(db, accountIDs[0]), ShouldBeNil)
+
+			Convey("Then the records should be linked to the new IDs", func() {
+				restored, err := FindAccountByID(db, accountIDs[0])
+				So(err, ShouldBeNil)
+				So(restored.UserID, ShouldEqual, target.ID)
+				So(restored.Balance, ShouldEqual, 100)
+				So(restored.Broker, ShouldEqual, "Schwab")
+				So(restored.Type, ShouldEqual, AccountRoth)
+				So(restored.CostBasisMethod, ShouldEqual, CostBasisFIFO)
+
+				var jobs []ImportJob
+				db.Preload("Rows").Where("account_id = ?", restored.ID).Find(&jobs)
+				So(jobs, ShouldHaveLength, 1)
+				So(jobs[0].UserID, ShouldEqual, target.ID)
+				So(jobs[0].Rows, ShouldHaveLength, 1)
+
+				var copies []Transaction
+				db.Where("account_id = ?", restored.ID).Order("id ASC").Find(&copies)
+				So(copies, ShouldHaveLength, 3)
+				So(*copies[0].ImportJobID, ShouldEqual, jobs[0].ID)
+				So(copies[0].Fingerprint, ShouldEqual, "buy")
+				So(copies[2].DividendTransactionID, ShouldNotBeNil)
+				So(*copies[2].DividendTransactionID, ShouldEqual, copies[1].ID)
+				So(copies[0].Date.Equal(date), ShouldBeTrue)
+
+				original, err := FetchPositionsByAccount(db, account.ID)
+				So(err, ShouldBeNil)
+				positions, err := FetchPositionsByAccount(db, restored.ID)
+				So(err, ShouldBeNil)
+				So(positions, ShouldHaveLength, len(original))
+				So(positions[0].Quantity, ShouldEqual, original[0].Quantity)
+				So(positions[0].CostBasis, ShouldEqual, original[0].CostBasis)
+			})
+		})
+
+		Convey("An archive from an unknown version should be rejected", func() {
+			_, err := RestoreBackup(db, target.ID, &Backup{Version: 99})
+			var invalid *InvalidBackupError
+			So(errors.As(err, &invalid), ShouldBeTrue)
+		})
+	})
+}
+
+func TestFetchTransactions(t *testing.T) {
+	Convey("Given transactions on stock and options", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+
+		account := Account{ID: 1, Name: "Test Account", UserID: 1}
+		db.Create(&account)
+
+		day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.Local) }
+		transactions := []Transaction{
+			{Date: day(2), Action: "Buy", Symbol: "AAPL", Description: "APPLE INC", Quantity: 100, Price: 180, Amount: -18000, AccountID: account.ID},
+			{Date: day(3), Action: "Sell to Open", Symbol: "AAPL 02/16/2024 200.00 C", Description: "CALL APPLE INC", Quantity: -1, Price: 2.1, Amount: 210, AccountID: account.ID},
+			{Date: day(3), Action: "Buy", Symbol: "AAPLX", Description: "APPLE FUND", Quantity: 1, Price: 10, Amount: -10, AccountID: account.ID},
+			{Date: day(4), Action: "Buy", Symbol: "MSFT", Description: "MICROSOFT CORP", Quantity: 10, Price: 370, Amount: -3700, AccountID: account.ID},
+			{Date: day(5), Action: "Cash Dividend", Symbol: "MSFT", Description: "MICROSOFT CORP", Amount: 7.5, AccountID: account.ID},
+			{Date: day(5), Action: "Buy", Symbol: "MSFT", Description: "MICROSOFT CORP", Quantity: 5, Price: 372, Amount: -1860, AccountID: account.ID},
+		}
+		So(CreateMany(db, transactions), ShouldBeNil)
+
+		Convey("Filters should combine", func() {
+			rows, err := FindTransactions(db, account.ID, TransactionFilter{Underlying: "AAPL"})
+			So(err, ShouldBeNil)
+			So(rows, ShouldHaveLength, 2)
+
+			min := -5000.0
+			rows, err = FindTransactions(db, account.ID, TransactionFilter{Actions: []string{"Buy"}, MinAmount: &min, From: day(3)})
+			So(err, ShouldBeNil)
+			So(rows, ShouldHaveLength, 3)
+
+			count, err := CountTransactions(db, account.ID, TransactionFilter{Search: "microsoft", To: day(4)})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+
+		Convey("Wildcards in the search and underlying should match literally", func() {
+			So(db.Create(&Transaction{Date: day(6), Action: "Journal", Symbol: "CASH", Description: "100% CASH_SWEEP", AccountID: account.ID}).Error, ShouldBeNil)
+
+			rows, err := FindTransactions(db, account.ID, TransactionFilter{Search: "%"})
+			So(err, ShouldBeNil)
+			So(rows, ShouldHaveLength, 1)
+			So(rows[0].Description, ShouldEqual, "100% CASH_SWEEP")
+
+			count, err := CountTransactions(db, account.ID, TransactionFilter{Search: "0% CASH_"})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			count, err = CountTransactions(db, account.ID, TransactionFilter{Search: "_"})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			count, err = CountTransactions(db, account.ID, TransactionFilter{Search: `\`})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+
+			count, err = CountTransactions(db, account.ID, TransactionFilter{Underlying: "AAP_"})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+
+		Convey("Cursor pages should walk every row once even when rows are added between pages", func() {
+			query := TransactionQuery{Sort: "date", Desc: true, Limit: 2}
+			first, cursor, err := FetchTransactions(db, account.ID, query)
+			So(err, ShouldBeNil)
+			So(first, ShouldHaveLength, 2)
+			So(first[0].ID, ShouldEqual, transactions[5].ID)
+			So(cursor, ShouldNotBeEmpty)
+
+			So(CreateMany(db, []Transaction{{Date: day(6), Action: "Buy", Symbol: "KO", Quantity: 1, Price: 60, Amount: -60, AccountID: account.ID}}), ShouldBeNil)
+
+			seen := map[uint]bool{first[0].ID: true, first[1].ID: true}
+			for cursor != "" {
+				query.Cursor = cursor
+				var page []Transaction
+				page, cursor, err = FetchTransactions(db, account.ID, query)
+				So(err, ShouldBeNil)
+				for _, t := range page {
+					So(seen[t.ID], ShouldBeFalse)
+					seen[t.ID] = true
+				}
+			}
+			So(seen, ShouldHaveLength, len(transactions))
+		})
+
+		Convey("Sorting by amount should order the rows", func() {
+			rows, _, err := FetchTransactions(db, account.ID, TransactionQuery{Sort: "amount", Limit: 10})
+			So(err, ShouldBeNil)
+			So(rows[0].Amount, ShouldEqual, -18000)
+			So(rows[len(rows)-1].Amount, ShouldEqual, 210)
+
+			_, _, err = FetchTransactions(db, account.ID, TransactionQuery{Sort: "fees; DROP TABLE", Limit: 10})
+			So(err, ShouldHaveSameTypeAs, &InvalidTransactionQueryError{})
+			_, _, err = FetchTransactions(db, account.ID, TransactionQuery{Cursor: "bogus", Limit: 10})
+			So(err, ShouldHaveSameTypeAs, &InvalidTransactionQueryError{})
+		})
+	})
+}
+
+func TestConsolidatedPositions(t *testing.T) {
+	Convey("Given the same stock and option held in two accounts", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+
+		taxable := Account{Name: "Taxable", UserID: 1}
+		db.Create(&taxable)
+		ira := Account{Name: "IRA", UserID: 1}
+		db.Create(&ira)
+		someoneElse := Account{Name: "Other", UserID: 2}
+		db.Create(&someoneElse)
+		shared := Account{Name: "Spouse", UserID: 3}
+		db.Create(&shared)
+		_, err = ShareAccount(db, shared.ID, 1, RoleViewer)
+		So(err, ShouldBeNil)
+
+		date := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+		call := "AAPL 02/16/2024 200.00 C"
+		So(CreateMany(db, []Transaction{
+			{Date: date, Action: "Buy", Symbol: "AAPL", Quantity: 100, Price: 180, Amount: -18000, AccountID: taxable.ID},
+			{Date: date, Action: "Sell to Open", Symbol: call, Quantity: 1, Price: 2, Amount: 200, AccountID: taxable.ID},
+			{Date: date, Action: "Buy", Symbol: "AAPL", Quantity: 50, Price: 150, Amount: -7500, AccountID: ira.ID},
+			{Date: date, Action: "Buy", Symbol: "MSFT", Quantity: 10, Price: 370, Amount: -3700, AccountID: ira.ID},
+			{Date: date, Action: "Buy", Symbol: "AAPL", Quantity: 1000, Price: 100, Amount: -100000, AccountID: someoneElse.ID},
+			{Date: date, Action: "Buy", Symbol: "NVDA", Quantity: 5, Price: 500, Amount: -2500, AccountID: shared.ID},
+		}), ShouldBeNil)
+		for _, id := range []uint{taxable.ID, ira.ID, someoneElse.ID, shared.ID} {
+			So(GeneratePositions(db, id), ShouldBeNil)
+		}
+
+		quotes := map[string]float64{"AAPL": 200, "AAPL240216C00200000": 3, "NVDA": 600}
+		var requested [][]string
+		prices := func(symbols []string) (map[string]float64, error) {
+			requested = append(requested, symbols)
+			found := make(map[string]float64)
+			for _, symbol := range symbols {
+				if p, ok := quotes[symbol]; ok {
+					found[symbol] = p
+				}
+			}
+			return found, nil
+		}
+
+		Convey("Then positions should be merged by symbol with a per-account breakdown", func() {
+			consolidated, err := ConsolidatedPositions(db, 1, prices)
+			So(err, ShouldBeNil)
+			So(consolidated, ShouldHaveLength, 4)
+			So(requested, ShouldResemble, [][]string{{"AAPL", "AAPL240216C00200000", "MSFT", "NVDA"}})
+
+			aapl := consolidated[0]
+			So(aapl.Symbol, ShouldEqual, "AAPL")
+			So(aapl.Quantity, ShouldEqual, 150)
+			So(aapl.TotalCost, ShouldEqual, 25500)
+			So(aapl.CostBasis, ShouldEqual, 170)
+			So(aapl.MarketValue, ShouldEqual, 30000)
+			So(aapl.Accounts, ShouldHaveLength, 2)
+			So(aapl.Accounts[1].AccountName, ShouldEqual, "IRA")
+			So(aapl.Accounts[1].MarketValue, ShouldEqual, 10000)
+
+			option := consolidated[1]
+			So(option.Symbol, ShouldEqual, call)
+			So(option.Quantity, ShouldEqual, -1)
+			So(option.CostBasis, ShouldEqual, 2)
+			So(option.TotalCost, ShouldEqual, -200)
+			So(option.MarketValue, ShouldEqual, -300)
+
+			msft := consolidated[2]
+			So(msft.PriceError, ShouldNotBeEmpty)
+			So(msft.MarketValue, ShouldEqual, 0)
+			So(msft.TotalCost, ShouldEqual, 3700)
+
+			nvda := consolidated[3]
+			So(nvda.MarketValue, ShouldEqual, 3000)
+			So(nvda.Accounts, ShouldHaveLength, 1)
+			So(nvda.Accounts[0].AccountName, ShouldEqual, "Spouse")
+			So(nvda.Accounts[0].Role, ShouldEqual, RoleViewer)
+		})
+
+		Convey("Then a failed price lookup should leave every position without a market value", func() {
+			consolidated, err := ConsolidatedPositions(db, 1, func([]string) (map[string]float64, error) {
+				return nil, fmt.Errorf("quote service unavailable")
+			})
+			So(err, ShouldBeNil)
+			So(consolidated, ShouldHaveLength, 4)
+			for _, c := range consolidated {
+				So(c.PriceError, ShouldEqual, "quote service unavailable")
+				So(c.MarketValue, ShouldEqual, 0)
+			}
+		})
+	})
+}
+
+func TestSessions(t *testing.T) {
+	Convey("Given a session", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+
+		session, token, err := CreateSession(db, 1, time.Hour)
+		So(err, ShouldBeNil)
+
+		Convey("Only the hash of the refresh token should be stored", func() {
+			var stored RefreshToken
+			So(db.Where("session_id = ?", session.ID).First(&stored).Error, ShouldBeNil)
+			So(stored.TokenHash, ShouldNotEqual, token)
+			So(stored.TokenHash, ShouldEqual, hashToken(token))
+		})
+
+		Convey("Rotating should replace the token and keep the session", func() {
+			rotated, next, err := RotateRefreshToken(db, token)
+			So(err, ShouldBeNil)
+			So(rotated.ID, ShouldEqual, session.ID)
+			So(next, ShouldNotEqual, token)
+
+			_, _, err = RotateRefreshToken(db, token)
+			So(err, ShouldEqual, ErrInvalidRefreshToken)
+			_, err = FindActiveSession(db, session.ID)
+			So(err, ShouldNotBeNil)
+			_, _, err = RotateRefreshToken(db, next)
+			So(err, ShouldEqual, ErrInvalidRefreshToken)
+		})
+
+		Convey("An expired session can't be refreshed", func() {
+			db.Model(session).Update("expires_at", time.Now().Add(-time.Minute))
+			_, _, err := RotateRefreshToken(db, token)
+			So(err, ShouldEqual, ErrInvalidRefreshToken)
+		})
+
+		Convey("Revoking every session of the user should end this one", func() {
+			So(RevokeUserSessions(db, 1), ShouldBeNil)
+			_, err := FindActiveSession(db, session.ID)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTOTP(t *testing.T) {
+	Convey("Codes should match the RFC 6238 SHA1 test vectors", t, func() {
+		// base32 of the ASCII seed "12345678901234567890"
+		secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+		code, err := TOTPCode(secret, time.Unix(59, 0))
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, "287082")
+		code, err = TOTPCode(secret, time.Unix(1111111109, 0))
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, "081804")
+	})
+
+	Convey("Given a user enrolled in two-factor", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+		user := &User{Email: "totp@example.com"}
+		_, err = CreateUser(db, user)
+		So(err, ShouldBeNil)
+
+		secret, err := StartTOTPEnrollment(db, user)
+		So(err, ShouldBeNil)
+		So(user.TOTPEnabled(), ShouldBeFalse)
+
+		code, _ := TOTPCode(secret, time.Now())
+		recovery, err := EnableTOTP(db, user, code)
+		So(err, ShouldBeNil)
+		So(recovery, ShouldHaveLength, 10)
+		So(user.TOTPEnabled(), ShouldBeTrue)
+
+		Convey("The code used to enroll can't be replayed", func() {
+			So(VerifySecondFactor(db, user, code, ""), ShouldEqual, ErrInvalidTOTPCode)
+		})
+
+		Convey("A recovery code should work once", func() {
+			So(VerifySecondFactor(db, user, "", strings.ToUpper(recovery[0])), ShouldBeNil)
+			So(VerifySecondFactor(db, user, "", recovery[0]), ShouldEqual, ErrInvalidTOTPCode)
+		})
+
+		Convey("Disabling should remove the secret and recovery codes", func() {
+			So(DisableTOTP(db, user), ShouldBeNil)
+			stored, err := FindUserByID(db, user.ID)
+			So(err, ShouldBeNil)
+			So(stored.TOTPEnabled(), ShouldBeFalse)
+			So(stored.TOTPSecret, ShouldBeEmpty)
+			var count int64
+			db.Model(&RecoveryCode{}).Where("user_id = ?", user.ID).Count(&count)
+			So(count, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestAccountShares(t *testing.T) {
+	Convey("Given an account shared with a viewer", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+		account := &Account{UserID: 1, Name: "Joint"}
+		So(db.Create(account).Error, ShouldBeNil)
+		So(db.Create(&Transaction{AccountID: account.ID, Symbol: "AAPL"}).Error, ShouldBeNil)
+		_, err = ShareAccount(db, account.ID, 2, RoleViewer)
+		So(err, ShouldBeNil)
+
+		Convey("Roles should be resolved for the owner, the viewer and anyone else", func() {
+			role, err := AccountRole(db, 1, account.ID)
+			So(err, ShouldBeNil)
+			So(role, ShouldEqual, RoleOwner)
+			role, err = AccountRole(db, 2, account.ID)
+			So(err, ShouldBeNil)
+			So(role, ShouldEqual, RoleViewer)
+			_, err = AccountRole(db, 3, account.ID)
+			So(err, ShouldNotBeNil)
+
+			So(RoleAllows(RoleViewer, RoleEditor), ShouldBeFalse)
+			So(RoleAllows(RoleOwner, RoleEditor), ShouldBeTrue)
+			So(RoleAllows("", RoleViewer), ShouldBeFalse)
+		})
+
+		Convey("Sharing again should change the role", func() {
+			share, err := ShareAccount(db, account.ID, 2, RoleEditor)
+			So(err, ShouldBeNil)
+			So(share.Role, ShouldEqual, RoleEditor)
+			shares, err := FetchAccountShares(db, account.ID)
+			So(err, ShouldBeNil)
+			So(shares, ShouldHaveLength, 1)
+		})
+
+		Convey("The viewer's transactions scope should include the account until it is unshared", func() {
+			var count int64
+			db.Model(&Transaction{}).Scopes(UserAccounts(2)).Count(&count)
+			So(count, ShouldEqual, 1)
+
+			So(UnshareAccount(db, account.ID, 2), ShouldBeNil)
+			db.Model(&Transaction{}).Scopes(UserAccounts(2)).Count(&count)
+			So(count, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestLoginPolicy(t *testing.T) {
+	Convey("Given a policy allowing 3 failures per email and 5 per IP address", t, func() {
+		db, err := setupDB()
+		So(err, ShouldBeNil)
+		policy := LoginPolicy{MaxAttempts: 3, MaxAttemptsPerIP: 5, BaseDelay: time.Second, Lockout: time.Minute}
+		now := time.Now()
+		fail := func(email, ip string, at time.Time) {
+			So(db.Create(&LoginAttempt{CreatedAt: at, Email: email, IP: ip, Result: LoginBadPassword}).Error, ShouldBeNil)
+		}
+
+		Convey("Failures below the limit shouldn't delay", func() {
+			fail("a@example.com", "10.0.0.1", now)
+			fail("a@example.com", "10.0.0.1", now)
+			wait, err := policy.RetryAfter(db, "a@example.com", "10.0.0.1", now)
+			So(err, ShouldBeNil)
+			So(wait, ShouldEqual, 0)
+		})
+
+		Convey("The delay should double with every failure past the limit", func() {
+			for i := 0; i < 5; i++ {
+				fail("a@example.com", "10.0.0.1", now)
+			}
+			wait, err := policy.RetryAfter(db, "A@Example.com ", "10.0.0.2", now)
+			So(err, ShouldBeNil)
+			So(wait, ShouldEqual, 4*time.Second)
+		})
+
+		Convey("The delay should stop at the lockout and failures older than it are forgotten", func() {
+			for i := 0; i < 20; i++ {
+				fail("a@example.com", "10.0.0.1", now.Add(-30*time.Second))
+			}
+			wait, err := policy.RetryAfter(db, "a@example.com", "10.0.0.2", now)
+			So(err, ShouldBeNil)
+			So(wait, ShouldEqual, 30*time.Second)
+
+			wait, err = policy.RetryAfter(db, "a@example.com", "10.0.0.2", now.Add(time.Minute))
+			So(err, ShouldBeNil)
+			So(wait, ShouldEqual, 0)
+		})
+
+		Convey("A successful login should reset the email but not the IP address", func() {
+			for i := 0; i < 6; i++ {
+				fail("a@example.com", "10.0.0.1", now.Add(-time.Second))
+			}
+			So(db.Create(&LoginAttempt{CreatedAt: now, Email: "a@example.com", IP: "10.0.0.1", Result: LoginSucceeded}).Error, ShouldBeNil)
+			wait, err := policy.RetryAfter(db, "a@example.com", "10.0.0.2", now)
+			So(err, ShouldBeNil)
+			So(wait, ShouldEqual, 0)
+			wait, err = policy.RetryAfter(db, "b@example.com", "10.0.0.1", now)
+			So(err, ShouldBeNil)
+			So(wait, ShouldBeGreaterThan, 0)
+		})
+	})
+}
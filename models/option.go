@@ -0,0 +1,91 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OptionSymbol is the parsed form of the "UNDERLYING MM/DD/YYYY STRIKE C/P" option symbol convention
+type OptionSymbol struct {
+	Underlying string
+	Expiration time.Time
+	Strike     float64
+	Type       string // "C" or "P"
+}
+
+// ParseOptionSymbol parses an option symbol, the bool is false when the symbol is not an option
+func ParseOptionSymbol(symbol string) (OptionSymbol, bool) {
+	parts := strings.Fields(symbol)
+	if len(parts) < 4 {
+		return OptionSymbol{}, false
+	}
+	expiration, err := time.Parse("01/02/2006", parts[1])
+	if err != nil {
+		return OptionSymbol{}, false
+	}
+	strike, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return OptionSymbol{}, false
+	}
+	if parts[3] != "C" && parts[3] != "P" {
+		return OptionSymbol{}, false
+	}
+	return OptionSymbol{
+		Underlying: parts[0],
+		Expiration: expiration,
+		Strike:     strike,
+		Type:       parts[3],
+	}, true
+}
+
+// String formats the option back into the project's symbol convention
+func (o OptionSymbol) String() string {
+	return fmt.Sprintf("%s %s %.2f %s", o.Underlying, o.Expiration.Format("01/02/2006"), o.Strike, o.Type)
+}
+
+// IsOptionSymbol reports whether the symbol follows the option symbol convention
+func IsOptionSymbol(symbol string) bool {
+	_, ok := ParseOptionSymbol(symbol)
+	return ok
+}
+
+// ParseOCCSymbol parses an OCC option symbol such as "AAPL  240216C00200000": a root padded to six
+// characters, the expiration as YYMMDD, C or P and the strike multiplied by 1000 in eight digits
+func ParseOCCSymbol(occ string) (OptionSymbol, bool) {
+	occ = strings.TrimSpace(occ)
+	if len(occ) < 16 {
+		return OptionSymbol{}, false
+	}
+	contract := occ[len(occ)-15:]
+	root := strings.TrimSpace(occ[:len(occ)-15])
+	if root == "" {
+		return OptionSymbol{}, false
+	}
+	expiration, err := time.Parse("060102", contract[:6])
+	if err != nil {
+		return OptionSymbol{}, false
+	}
+	optType := contract[6:7]
+	if optType != "C" && optType != "P" {
+		return OptionSymbol{}, false
+	}
+	strike, err := strconv.ParseInt(contract[7:], 10, 64)
+	if err != nil {
+		return OptionSymbol{}, false
+	}
+	return OptionSymbol{
+		Underlying: root,
+		Expiration: expiration,
+		Strike:     float64(strike) / 1000,
+		Type:       optType,
+	}, true
+}
+
+// QuoteSymbol formats the option as an OCC contract without the root padding ("AAPL240216C00200000"),
+// the form quote providers look options up by
+func (o OptionSymbol) QuoteSymbol() string {
+	return fmt.Sprintf("%s%s%s%08d", o.Underlying, o.Expiration.Format("060102"), o.Type, int64(math.Round(o.Strike*1000)))
+}
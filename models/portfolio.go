@@ -0,0 +1,125 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// optionMultiplier is the number of shares an option contract covers
+const optionMultiplier = 100
+
+// AccountPosition is the part of a consolidated position held in one account
+type AccountPosition struct {
+	AccountID   uint    `json:"account_id"`
+	AccountName string  `json:"account_name"`
+	Role        string  `json:"role"`
+	Quantity    float64 `json:"quantity"`
+	CostBasis   float64 `json:"cost_basis"`
+	TotalCost   float64 `json:"total_cost"`
+	MarketValue float64 `json:"market_value"`
+}
+
+// ConsolidatedPosition is an open position merged across all accounts a user can view. CostBasis is
+// the combined cost per share or contract, TotalCost and MarketValue include the option multiplier.
+type ConsolidatedPosition struct {
+	Symbol           string            `json:"symbol"`
+	UnderlyingSymbol string            `json:"underlying_symbol"`
+	Quantity         float64           `json:"quantity"`
+	CostBasis        float64           `json:"cost_basis"`
+	TotalCost        float64           `json:"total_cost"`
+	Price            float64           `json:"price"`
+	MarketValue      float64           `json:"market_value"`
+	PriceError       string            `json:"price_error,omitempty"`
+	Accounts         []AccountPosition `json:"accounts"`
+}
+
+// ConsolidatedPositions merges the open positions of the accounts a user owns or has shared with
+// them by symbol. prices looks up the current prices of the quote symbols in one call, positions
+// without a price have no market value.
+func ConsolidatedPositions(db *gorm.DB, userID uint, prices func(symbols []string) (map[string]float64, error)) ([]ConsolidatedPosition, error) {
+	accounts, err := FetchUserAccounts(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return []ConsolidatedPosition{}, nil
+	}
+	byID := make(map[uint]Account)
+	accountIDs := make([]uint, len(accounts))
+	for i, a := range accounts {
+		byID[a.ID] = a
+		accountIDs[i] = a.ID
+	}
+
+	var positions []Position
+	if err := db.Where("account_id IN ? AND opened = ?", accountIDs, true).Order("account_id ASC").Find(&positions).Error; err != nil {
+		return nil, err
+	}
+
+	bySymbol := make(map[string]*ConsolidatedPosition)
+	var symbols []string
+	for _, p := range positions {
+		multiplier := 1.0
+		if IsOptionSymbol(p.Symbol) {
+			multiplier = optionMultiplier
+		}
+
+		c, ok := bySymbol[p.Symbol]
+		if !ok {
+			c = &ConsolidatedPosition{Symbol: p.Symbol, UnderlyingSymbol: p.UnderlyingSymbol}
+			bySymbol[p.Symbol] = c
+			symbols = append(symbols, p.Symbol)
+		}
+		totalCost := p.CostBasis * p.Quantity * multiplier
+		c.Quantity += p.Quantity
+		c.TotalCost += totalCost
+		c.Accounts = append(c.Accounts, AccountPosition{
+			AccountID:   p.AccountID,
+			AccountName: byID[p.AccountID].Name,
+			Role:        byID[p.AccountID].Role,
+			Quantity:    p.Quantity,
+			CostBasis:   p.CostBasis,
+			TotalCost:   totalCost,
+		})
+	}
+
+	sort.Strings(symbols)
+	quoteSymbols := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		quoteSymbols[i] = symbol
+		if option, ok := ParseOptionSymbol(symbol); ok {
+			quoteSymbols[i] = option.QuoteSymbol()
+		}
+	}
+	// All prices are looked up together, a failed lookup leaves every position without a price
+	current, priceErr := prices(quoteSymbols)
+
+	consolidated := make([]ConsolidatedPosition, 0, len(symbols))
+	for i, symbol := range symbols {
+		c := bySymbol[symbol]
+		multiplier := 1.0
+		if IsOptionSymbol(symbol) {
+			multiplier = optionMultiplier
+		}
+		if c.Quantity != 0 {
+			c.CostBasis = c.TotalCost / (c.Quantity * multiplier)
+		}
+
+		price, ok := current[quoteSymbols[i]]
+		if priceErr != nil {
+			c.PriceError = priceErr.Error()
+		} else if !ok {
+			c.PriceError = fmt.Sprintf("no quote for %s", quoteSymbols[i])
+		} else {
+			c.Price = price
+			c.MarketValue = price * c.Quantity * multiplier
+			for j := range c.Accounts {
+				c.Accounts[j].MarketValue = price * c.Accounts[j].Quantity * multiplier
+			}
+		}
+		consolidated = append(consolidated, *c)
+	}
+	return consolidated, nil
+}
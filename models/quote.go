@@ -22,6 +22,24 @@ func GetCurrentPrice(symbol string) (float64, error) {
 	return q.Bid, nil
 }
 
+// GetCurrentPrices looks up the current prices of the symbols in one request, symbols without a
+// quote are missing from the result
+func GetCurrentPrices(symbols []string) (map[string]float64, error) {
+	prices := make(map[string]float64, len(symbols))
+	if len(symbols) == 0 {
+		return prices, nil
+	}
+	iter := quote.List(symbols)
+	for iter.Next() {
+		q := iter.Quote()
+		prices[q.Symbol] = q.Bid
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
 func GetHistoricalPrices(symbol string, startDate time.Time) ([]HistoricalPrice, error) {
 	t := time.Now()
 	params := &chart.Params{
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Option strategy types used by the premium income report
+const (
+	StrategyCoveredCall    = "covered call"
+	StrategyCashSecuredPut = "cash-secured put"
+	StrategySpread         = "spread"
+	StrategyUncoveredCall  = "uncovered call"
+	StrategyLongOption     = "long option"
+)
+
+// OptionPremiumIncome is the net option premium for a month, underlying and strategy
+type OptionPremiumIncome struct {
+	Month      string  `json:"month"`
+	Underlying string  `json:"underlying"`
+	Strategy   string  `json:"strategy"`
+	Collected  float64 `json:"collected"`
+	Paid       float64 `json:"paid"`
+	Net        float64 `json:"net"`
+}
+
+// OptionPremiumReport totals option premium collected and paid by month, underlying and strategy.
+// Premium is collected on "Sell to Open" and paid on "Buy to Close"; the long legs of a spread are
+// included so the spread's net premium is correct.
+func OptionPremiumReport(db *gorm.DB, accountID uint) ([]OptionPremiumIncome, error) {
+	var transactions []Transaction
+	if err := db.Where("account_id = ?", accountID).Order("date ASC, id ASC").Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+
+	strategies := classifyOptionStrategies(transactions)
+
+	totals := make(map[[3]string]*OptionPremiumIncome)
+	for _, t := range transactions {
+		opt, ok := ParseOptionSymbol(t.Symbol)
+		if !ok {
+			continue
+		}
+		parts, ok := strategies[t.ID]
+		if !ok {
+			continue
+		}
+
+		action := strings.ToLower(t.Action)
+		switch action {
+		case "sell to open", "buy to close", "buy to open", "sell to close":
+		default:
+			continue
+		}
+
+		for _, part := range parts {
+			// The long legs only count towards a spread's net premium
+			if (action == "buy to open" || action == "sell to close") && part.strategy != StrategySpread {
+				continue
+			}
+
+			month := t.Date.Format("2006-01")
+			key := [3]string{month, opt.Underlying, part.strategy}
+			row, exists := totals[key]
+			if !exists {
+				row = &OptionPremiumIncome{Month: month, Underlying: opt.Underlying, Strategy: part.strategy}
+				totals[key] = row
+			}
+
+			// Amount signs are not normalized until positions are generated, so use the action
+			amount := math.Abs(t.Amount) * part.fraction
+			if strings.HasPrefix(action, "sell") {
+				row.Collected += amount
+			} else {
+				row.Paid += amount
+			}
+			row.Net = row.Collected - row.Paid
+		}
+	}
+
+	report := make([]OptionPremiumIncome, 0, len(totals))
+	for _, row := range totals {
+		report = append(report, *row)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Month != report[j].Month {
+			return report[i].Month < report[j].Month
+		}
+		if report[i].Underlying != report[j].Underlying {
+			return report[i].Underlying < report[j].Underlying
+		}
+		return report[i].Strategy < report[j].Strategy
+	})
+	return report, nil
+}
+
+// strategyShare is the part of an option transaction that belongs to a strategy, a close that
+// spans opening lots of different strategies is split by quantity
+type strategyShare struct {
+	strategy string
+	fraction float64
+}
+
+// optionLot is the open quantity of an opening transaction
+type optionLot struct {
+	strategy   string
+	underlying string
+	quantity   float64
+}
+
+// classifyOptionStrategies assigns a strategy to every opening option transaction, keyed by
+// transaction ID, based on the share position when it was opened. Each 100 shares cover one
+// short call while it is open, a call opened beyond the uncovered shares is uncovered. Closes,
+// expirations and assignments carry the strategy of the lots they close, oldest first.
+// Transactions must be ordered by date ascending.
+func classifyOptionStrategies(transactions []Transaction) map[uint][]strategyShare {
+	strategies := make(map[uint][]strategyShare)
+	shares := make(map[string]float64)
+	// covering are the shares of an underlying already covering open calls
+	covering := make(map[string]float64)
+	// spreads are the opening legs already paired with an earlier leg of the same spread
+	spreads := make(map[uint]bool)
+	lots := make(map[string][]*optionLot)
+
+	for i, t := range transactions {
+		opt, ok := ParseOptionSymbol(t.Symbol)
+		if !ok {
+			if !IsDividendAction(t.Action) {
+				shares[t.Symbol] += signedQuantity(t)
+			}
+			continue
+		}
+
+		quantity := math.Abs(t.Quantity)
+		action := strings.ToLower(t.Action)
+		if action != "sell to open" && action != "buy to open" {
+			if parts := closeLots(lots, covering, t.Symbol, quantity); len(parts) > 0 {
+				strategies[t.ID] = parts
+			}
+			continue
+		}
+
+		open := func(strategy string, contracts float64) {
+			if contracts <= 0 {
+				return
+			}
+			strategies[t.ID] = append(strategies[t.ID], strategyShare{strategy: strategy, fraction: contracts / quantity})
+			lots[t.Symbol] = append(lots[t.Symbol], &optionLot{strategy: strategy, underlying: opt.Underlying, quantity: contracts})
+		}
+
+		if spreads[t.ID] {
+			open(StrategySpread, quantity)
+		} else if legs := spreadLegs(transactions, i, opt); len(legs) > 0 {
+			// A spread opens legs in both directions on the same underlying, expiration and type on the same day
+			open(StrategySpread, quantity)
+			for _, leg := range legs {
+				spreads[leg] = true
+			}
+		} else {
+			switch {
+			case action == "buy to open":
+				open(StrategyLongOption, quantity)
+			case opt.Type == "P":
+				open(StrategyCashSecuredPut, quantity)
+			default:
+				// Only whole contracts are covered by the shares not covering an earlier call
+				covered := math.Min(quantity, math.Floor((shares[opt.Underlying]-covering[opt.Underlying])/100))
+				covered = math.Max(covered, 0)
+				covering[opt.Underlying] += 100 * covered
+				open(StrategyCoveredCall, covered)
+				open(StrategyUncoveredCall, quantity-covered)
+			}
+		}
+	}
+	return strategies
+}
+
+// closeLots takes quantity off the oldest open lots of the symbol and returns the strategies
+// of the closed quantity. Closed covered calls release their shares from covering.
+func closeLots(lots map[string][]*optionLot, covering map[string]float64, symbol string, quantity float64) []strategyShare {
+	if quantity == 0 {
+		return nil
+	}
+	var parts []strategyShare
+	remaining := quantity
+	for remaining > 0 && len(lots[symbol]) > 0 {
+		lot := lots[symbol][0]
+		closed := math.Min(lot.quantity, remaining)
+		parts = append(parts, strategyShare{strategy: lot.strategy, fraction: closed / quantity})
+		lot.quantity -= closed
+		remaining -= closed
+		if lot.strategy == StrategyCoveredCall {
+			covering[lot.underlying] -= 100 * closed
+		}
+		if lot.quantity <= 0 {
+			lots[symbol] = lots[symbol][1:]
+		}
+	}
+	return parts
+}
+
+// spreadLegs returns the IDs of opening legs on the same day that form a spread with transactions[i]
+func spreadLegs(transactions []Transaction, i int, opt OptionSymbol) []uint {
+	t := transactions[i]
+	action := strings.ToLower(t.Action)
+
+	// Transactions are ordered by date so the same-day rows sit next to i
+	start, end := i, i
+	for start > 0 && transactions[start-1].Date.Equal(t.Date) {
+		start--
+	}
+	for end < len(transactions)-1 && transactions[end+1].Date.Equal(t.Date) {
+		end++
+	}
+
+	var legs []uint
+	for _, other := range transactions[start : end+1] {
+		if other.Symbol == t.Symbol {
+			continue
+		}
+		otherAction := strings.ToLower(other.Action)
+		if otherAction == action || (otherAction != "sell to open" && otherAction != "buy to open") {
+			continue
+		}
+		otherOpt, ok := ParseOptionSymbol(other.Symbol)
+		if !ok {
+			continue
+		}
+		if otherOpt.Underlying == opt.Underlying && otherOpt.Expiration.Equal(opt.Expiration) && otherOpt.Type == opt.Type {
+			legs = append(legs, other.ID)
+		}
+	}
+	return legs
+}
+
+// signedQuantity returns the share quantity of a transaction with sells made negative
+func signedQuantity(t Transaction) float64 {
+	if strings.Contains(strings.ToLower(t.Action), "sell") {
+		return -math.Abs(t.Quantity)
+	}
+	return t.Quantity
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidRefreshToken is returned for a refresh token that is unknown, expired, already used
+// or belongs to a revoked session
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// Session is a login. Access tokens carry the session ID so revoking the session stops them
+// from working before they expire.
+type Session struct {
+	gorm.Model
+	ID        uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"index"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// RefreshToken is a single use token that extends a session. Only the hash of the token is stored.
+type RefreshToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	SessionID uint   `gorm:"index"`
+	TokenHash string `gorm:"size:64;uniqueIndex"`
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// Active reports whether the session can still be used
+func (s *Session) Active() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+// CreateSession starts a session for the user and returns its first refresh token
+func CreateSession(db *gorm.DB, userID uint, ttl time.Duration) (*Session, string, error) {
+	session := &Session{UserID: userID, ExpiresAt: time.Now().Add(ttl)}
+	var token string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		var err error
+		token, err = issueRefreshToken(tx, session.ID)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return session, token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one on the same session. Using a token
+// a second time means it was copied, so the whole session is revoked.
+func RotateRefreshToken(db *gorm.DB, token string) (*Session, string, error) {
+	var session Session
+	var next string
+	reused := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var refresh RefreshToken
+		if err := tx.Where("token_hash = ?", hashToken(token)).First(&refresh).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+		if err := tx.First(&session, refresh.SessionID).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+		if refresh.UsedAt != nil {
+			reused = true
+			return ErrInvalidRefreshToken
+		}
+		if !session.Active() {
+			return ErrInvalidRefreshToken
+		}
+
+		// Only one request can use the token, a concurrent one finds it already used
+		result := tx.Model(&RefreshToken{}).Where("id = ? AND used_at IS NULL", refresh.ID).Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidRefreshToken
+		}
+
+		var err error
+		next, err = issueRefreshToken(tx, session.ID)
+		return err
+	})
+	if reused {
+		if err := RevokeSession(db, session.ID); err != nil {
+			return nil, "", err
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &session, next, nil
+}
+
+// FindActiveSession fetches a session that is neither revoked nor expired
+func FindActiveSession(db *gorm.DB, id uint) (*Session, error) {
+	var session Session
+	if err := db.First(&session, id).Error; err != nil {
+		return nil, err
+	}
+	if !session.Active() {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &session, nil
+}
+
+// RevokeSession ends a session, its access and refresh tokens stop working
+func RevokeSession(db *gorm.DB, id uint) error {
+	return db.Model(&Session{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserSessions ends every session of a user
+func RevokeUserSessions(db *gorm.DB, userID uint) error {
+	return db.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", time.Now()).Error
+}
+
+func issueRefreshToken(db *gorm.DB, sessionID uint) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	if err := db.Create(&RefreshToken{SessionID: sessionID, TokenHash: hashToken(token)}).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// randomToken returns 32 random bytes encoded for use in URLs and headers
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is how secret tokens are stored, they are random so a plain hash is enough
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
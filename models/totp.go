@@ -0,0 +1,216 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TOTP parameters from RFC 6238 as authenticator apps expect them by default
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many time steps before and after now are accepted to allow for clock drift
+	totpSkew = 1
+	// recoveryCodeCount is how many recovery codes are issued when two-factor is enabled
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled    = errors.New("two-factor authentication enrollment has not been started")
+	ErrInvalidTOTPCode    = errors.New("invalid two-factor code")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RecoveryCode is a single use code that replaces a TOTP code when the authenticator is lost.
+// Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserID   uint   `gorm:"index"`
+	CodeHash string `gorm:"size:64;index"`
+	UsedAt   *time.Time
+}
+
+// TOTPEnabled reports whether the user has to give a TOTP code to log in
+func (u *User) TOTPEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// TOTPProvisioningURI is the otpauth URI authenticator apps read from a QR code
+func TOTPProvisioningURI(issuer, email, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + email)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// StartTOTPEnrollment gives the user a new TOTP secret. Two-factor stays disabled until a code
+// from the secret is confirmed with EnableTOTP.
+func StartTOTPEnrollment(db *gorm.DB, u *User) (string, error) {
+	if u.TOTPEnabled() {
+		return "", ErrTOTPAlreadyEnabled
+	}
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := totpEncoding.EncodeToString(b)
+	if err := db.Model(u).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_counter": 0}).Error; err != nil {
+		return "", err
+	}
+	u.TOTPSecret = secret
+	return secret, nil
+}
+
+// EnableTOTP turns two-factor on once the code proves the authenticator has the secret, and
+// returns the recovery codes. The codes are only available now.
+func EnableTOTP(db *gorm.DB, u *User, code string) ([]string, error) {
+	if u.TOTPEnabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if u.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := useTOTPCode(tx, u, code, time.Now()); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", u.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for i := 0; i < recoveryCodeCount; i++ {
+			code, err := newRecoveryCode()
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&RecoveryCode{UserID: u.ID, CodeHash: hashToken(code)}).Error; err != nil {
+				return err
+			}
+			codes = append(codes, code)
+		}
+		now := time.Now()
+		u.TOTPEnabledAt = &now
+		return tx.Model(u).Update("totp_enabled_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor off and removes the secret and recovery codes
+func DisableTOTP(db *gorm.DB, u *User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", u.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		u.TOTPSecret = ""
+		u.TOTPEnabledAt = nil
+		u.TOTPLastCounter = 0
+		return tx.Model(u).Select("TOTPSecret", "TOTPEnabledAt", "TOTPLastCounter").Updates(u).Error
+	})
+}
+
+// VerifySecondFactor accepts either a current TOTP code or an unused recovery code
+func VerifySecondFactor(db *gorm.DB, u *User, code, recoveryCode string) error {
+	if !u.TOTPEnabled() {
+		return ErrTOTPNotEnrolled
+	}
+	if recoveryCode != "" {
+		normalized := strings.ToLower(strings.TrimSpace(recoveryCode))
+		result := db.Model(&RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", u.ID, hashToken(normalized)).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+	return useTOTPCode(db, u, code, time.Now())
+}
+
+// useTOTPCode checks a code and records its time step, a step can only be used once
+func useTOTPCode(db *gorm.DB, u *User, code string, now time.Time) error {
+	counter, ok := validateTOTP(u.TOTPSecret, code, now)
+	if !ok || counter <= u.TOTPLastCounter {
+		return ErrInvalidTOTPCode
+	}
+	result := db.Model(&User{}).Where("id = ? AND totp_last_counter < ?", u.ID, counter).Update("totp_last_counter", counter)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTOTPCode
+	}
+	u.TOTPLastCounter = counter
+	return nil
+}
+
+// validateTOTP returns the time step the code matches
+func validateTOTP(secret, code string, now time.Time) (uint64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := uint64(now.Unix()) / totpPeriod
+	for offset := -totpSkew; offset <= totpSkew; offset++ {
+		counter := current + uint64(offset)
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPCode is the code an authenticator app shows for the secret at the given time
+func TOTPCode(secret string, at time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, uint64(at.Unix())/totpPeriod), nil
+}
+
+// totpCode computes the HOTP value (RFC 4226) for a time step
+func totpCode(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// newRecoveryCode returns a code like "k3x9-p2qa-7mvd"
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	raw := strings.ToLower(totpEncoding.EncodeToString(b))[:12]
+	return raw[:4] + "-" + raw[4:8] + "-" + raw[8:], nil
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -27,6 +29,25 @@ type Transaction struct {
 	Account     Account `gorm:"foreignKey:AccountID"`
 	// Remove PositionID and Position fields
 	Processed bool `gorm:"default:false"` // Add this field
+	// DividendTransactionID links a "Reinvest Shares" lot to the dividend cash row that paid for it
+	DividendTransactionID *uint `gorm:"index"`
+	// ImportJobID is the import batch that created the transaction, nil for manual entries
+	ImportJobID *uint `gorm:"index"`
+	// Fingerprint identifies the source row an imported transaction came from, see ContentHash
+	Fingerprint string `gorm:"size:64;index"`
+}
+
+// dividendActions are the cash dividend rows a reinvestment lot can be funded by
+var dividendActions = []string{"Reinvest Dividend", "Qualified Dividend", "Cash Dividend", "Non-Qualified Div"}
+
+// IsDividendAction reports whether the action is a dividend cash row rather than a share movement
+func IsDividendAction(action string) bool {
+	for _, a := range dividendActions {
+		if strings.EqualFold(action, a) {
+			return true
+		}
+	}
+	return false
 }
 
 // MarshalJSON customizes the JSON representation of the Transaction struct
@@ -41,6 +62,62 @@ func (t Transaction) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// ContentHash returns a stable hash of the transaction as it was read from the source file.
+// occurrence numbers identical rows within the same file (1, 2, ...) so separate fills with the
+// same values stay distinct while re-importing the same rows produces the same hashes.
+func (t Transaction) ContentHash(occurrence int) string {
+	content := strings.Join([]string{
+		t.Date.Format("2006-01-02"),
+		t.Action,
+		t.Symbol,
+		t.Description,
+		strconv.FormatFloat(t.Quantity, 'f', -1, 64),
+		strconv.FormatFloat(t.Price, 'f', -1, 64),
+		strconv.FormatFloat(t.Fees, 'f', -1, 64),
+		strconv.FormatFloat(t.Amount, 'f', -1, 64),
+		strconv.Itoa(occurrence),
+	}, "|")
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindTransactionIDsByFingerprint returns the IDs of the account's transactions with the given fingerprints
+func FindTransactionIDsByFingerprint(db *gorm.DB, accountID uint, fingerprints []string) (map[string]uint, error) {
+	found := make(map[string]uint)
+	for start := 0; start < len(fingerprints); start += 500 {
+		end := start + 500
+		if end > len(fingerprints) {
+			end = len(fingerprints)
+		}
+		var transactions []Transaction
+		if err := db.Select("id", "fingerprint").Where("account_id = ? AND fingerprint IN ?", accountID, fingerprints[start:end]).Find(&transactions).Error; err != nil {
+			return nil, err
+		}
+		for _, t := range transactions {
+			found[t.Fingerprint] = t.ID
+		}
+	}
+	return found, nil
+}
+
+// CountUnfingerprintedTransactions counts the account's transactions without a fingerprint,
+// these were entered manually or imported before fingerprints were stored
+func CountUnfingerprintedTransactions(db *gorm.DB, accountID uint) (int64, error) {
+	var count int64
+	err := db.Model(&Transaction{}).Where("account_id = ? AND (fingerprint = '' OR fingerprint IS NULL)", accountID).Count(&count).Error
+	return count, err
+}
+
+// FindUnfingerprintedMatches returns the account's transactions without a fingerprint that match
+// the source row. Quantity and amount are compared by magnitude since GeneratePositions normalizes
+// their signs after the row is stored.
+func FindUnfingerprintedMatches(db *gorm.DB, accountID uint, t Transaction) ([]Transaction, error) {
+	var transactions []Transaction
+	err := db.Where("account_id = ? AND (fingerprint = '' OR fingerprint IS NULL) AND date = ? AND action = ? AND symbol = ? AND description = ? AND ABS(quantity) = ? AND fees = ? AND ABS(amount) = ?",
+		accountID, t.Date, t.Action, t.Symbol, t.Description, math.Abs(t.Quantity), t.Fees, math.Abs(t.Amount)).Order("id ASC").Find(&transactions).Error
+	return transactions, err
+}
+
 // DeleteTransaction deletes a transaction by ID and removes the associated position if no other transactions exist
 func DeleteTransaction(db *gorm.DB, id uint) error {
 	var transaction Transaction
@@ -56,6 +133,14 @@ func DeleteTransaction(db *gorm.DB, id uint) error {
 	return nil
 }
 
+// UpdateTransaction saves the editable fields of a transaction and marks it unprocessed so
+// generating positions normalizes it again. The fingerprint is kept so re-importing the source
+// row still finds the corrected transaction.
+func UpdateTransaction(db *gorm.DB, t *Transaction) error {
+	t.Processed = false
+	return db.Model(t).Select("Date", "Action", "Symbol", "Description", "Quantity", "Price", "Fees", "Amount", "Processed").Updates(t).Error
+}
+
 // FindTransactionByID fetches a transaction by ID and includes the associated account information
 func FindTransactionByID(db *gorm.DB, id uint) (*Transaction, error) {
 	var transaction Transaction
@@ -90,34 +175,6 @@ func FindAllByAccount(db *gorm.DB, a *Account) ([]Transaction, error) {
 	return transactions, nil
 }
 
-// FetchTransactionsByAccountIDAndSymbol fetches transactions by account ID and symbol with pagination
-func FetchTransactionsByAccountIDAndSymbol(db *gorm.DB, accountID uint, symbol string, page, limit int) ([]Transaction, error) {
-	var transactions []Transaction
-
-	query := db.Where("account_id = ?", accountID)
-	if symbol != "" {
-		query = query.Where("symbol = ?", symbol)
-	}
-
-	offset := (page - 1) * limit
-	if err := query.Order("date DESC").Offset(offset).Limit(limit).Find(&transactions).Error; err != nil {
-		return nil, err
-	}
-
-	return transactions, nil
-}
-
-// CountTransactionsByAccountIDAndSymbol counts the number of transactions by account ID and optional symbol
-func CountTransactionsByAccountIDAndSymbol(db *gorm.DB, accountID uint, symbol string) (int64, error) {
-	var count int64
-	query := db.Model(&Transaction{}).Where("account_id = ?", accountID)
-	if symbol != "" {
-		query = query.Where("symbol = ?", symbol)
-	}
-	err := query.Count(&count).Error
-	return count, err
-}
-
 // GetLastTransactionDate returns the date of the last transaction for the given account
 func GetLastTransactionDate(db *gorm.DB, accountID uint) (time.Time, error) {
 	var lastTransaction Transaction
@@ -162,6 +219,13 @@ func GeneratePositions(db *gorm.DB, accountID uint) error {
 				continue
 			}
 		}
+		if t.Action == "Reinvest Shares" {
+			err := HandleReinvestShares(db, t)
+			if err != nil {
+				log.Println("Handle Reinvest Shares: ", err)
+				continue
+			}
+		}
 	}
 
 	// Reload transaction now that Splits are handled.
@@ -177,6 +241,10 @@ func GeneratePositions(db *gorm.DB, accountID uint) error {
 	positions := make(map[string]*Position)
 
 	for _, t := range transactions {
+		// Dividend cash rows don't move shares, the reinvested lot carries quantity and cost
+		if IsDividendAction(t.Action) {
+			continue
+		}
 		if _, exists := positions[t.Symbol]; !exists {
 			if !validOpenTransaction(t) {
 				continue
@@ -322,10 +390,37 @@ func HandleReverseSplit(db *gorm.DB, t Transaction) error {
 	return nil
 }
 
+// HandleReinvestShares links a dividend reinvestment lot to the dividend cash row on the same day
+func HandleReinvestShares(db *gorm.DB, t Transaction) error {
+	if t.DividendTransactionID == nil {
+		var candidates []Transaction
+		if err := db.Where("account_id = ? AND symbol = ? AND date = ? AND action IN ?", t.AccountID, t.Symbol, t.Date, dividendActions).Find(&candidates).Error; err != nil {
+			return err
+		}
+
+		// Prefer the dividend whose cash amount matches the reinvested amount
+		var match *Transaction
+		for i, d := range candidates {
+			if match == nil || math.Abs(d.Amount-math.Abs(t.Amount)) < math.Abs(match.Amount-math.Abs(t.Amount)) {
+				match = &candidates[i]
+			}
+		}
+		if match != nil {
+			t.DividendTransactionID = &match.ID
+		}
+	}
+
+	t.Processed = true
+	if err := db.Save(&t).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
 func validOpenTransaction(t Transaction) bool {
 	// This transaction needs to be a valid Opening
-	// Buy, Sell Short, Sell to Open, Buy to Open
-	openActions := []string{"buy", "sell short", "sell to open", "buy to open", "reverse split"}
+	// Buy, Sell Short, Sell to Open, Buy to Open, Reinvest Shares
+	openActions := []string{"buy", "sell short", "sell to open", "buy to open", "reverse split", "reinvest shares"}
 	for _, a := range openActions {
 		if strings.ToLower(t.Action) == a {
 			return true
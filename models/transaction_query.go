@@ -0,0 +1,228 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TransactionFilter narrows the transactions of an account, zero values don't filter
+type TransactionFilter struct {
+	// Symbol matches the symbol exactly
+	Symbol string
+	// Underlying matches the symbol itself and the options written on it
+	Underlying string
+	// Actions matches any of the actions
+	Actions   []string
+	From      time.Time
+	To        time.Time
+	MinAmount *float64
+	MaxAmount *float64
+	// Search matches part of the description
+	Search string
+}
+
+// escapeLike escapes the LIKE wildcards in user input, the pattern needs likeEscape
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// likeEscape is the ESCAPE clause making backslash the escape character. MySQL string literals
+// use backslash escapes themselves so the backslash has to be doubled there.
+func likeEscape(db *gorm.DB) string {
+	if db.Dialector.Name() == "mysql" {
+		return `ESCAPE '\\'`
+	}
+	return `ESCAPE '\'`
+}
+
+func (f TransactionFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.Symbol != "" {
+		query = query.Where("symbol = ?", f.Symbol)
+	}
+	if f.Underlying != "" {
+		query = query.Where("(symbol = ? OR symbol LIKE ? "+likeEscape(query)+")", f.Underlying, escapeLike(f.Underlying)+" %")
+	}
+	if len(f.Actions) > 0 {
+		query = query.Where("action IN ?", f.Actions)
+	}
+	if !f.From.IsZero() {
+		query = query.Where("date >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("date <= ?", f.To)
+	}
+	if f.MinAmount != nil {
+		query = query.Where("amount >= ?", *f.MinAmount)
+	}
+	if f.MaxAmount != nil {
+		query = query.Where("amount <= ?", *f.MaxAmount)
+	}
+	if f.Search != "" {
+		query = query.Where("description LIKE ? "+likeEscape(query), "%"+escapeLike(f.Search)+"%")
+	}
+	return query
+}
+
+// FindTransactions fetches the transactions of an account matching the filter, oldest first
+func FindTransactions(db *gorm.DB, accountID uint, f TransactionFilter) ([]Transaction, error) {
+	var transactions []Transaction
+	query := f.apply(db.Where("account_id = ?", accountID))
+	if err := query.Order("date ASC, id ASC").Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// CountTransactions counts the transactions of an account matching the filter
+func CountTransactions(db *gorm.DB, accountID uint, f TransactionFilter) (int64, error) {
+	var count int64
+	err := f.apply(db.Model(&Transaction{}).Where("account_id = ?", accountID)).Count(&count).Error
+	return count, err
+}
+
+// TransactionSortFields are the columns transactions can be sorted by
+var TransactionSortFields = []string{"date", "symbol", "action", "quantity", "price", "amount"}
+
+// TransactionQuery selects a page of transactions. Pages are read either by Page or, when
+// Cursor is set, after the last transaction of the previous page so rows inserted meanwhile
+// don't shift the pages.
+type TransactionQuery struct {
+	Filter TransactionFilter
+	Sort   string // one of TransactionSortFields, date by default
+	Desc   bool
+	Page   int
+	Limit  int
+	Cursor string
+}
+
+// InvalidTransactionQueryError is returned for an unknown sort field or a malformed cursor
+type InvalidTransactionQueryError struct {
+	Reason string
+}
+
+func (e *InvalidTransactionQueryError) Error() string {
+	return e.Reason
+}
+
+// transactionCursor is the sort value and ID of the last transaction of a page
+type transactionCursor struct {
+	Value json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+// FetchTransactions fetches a page of transactions of an account and the cursor of the next
+// page, which is empty on the last page
+func FetchTransactions(db *gorm.DB, accountID uint, q TransactionQuery) ([]Transaction, string, error) {
+	if q.Sort == "" {
+		q.Sort = "date"
+	}
+	if !validSortField(q.Sort) {
+		return nil, "", &InvalidTransactionQueryError{Reason: fmt.Sprintf("invalid sort field %q", q.Sort)}
+	}
+	direction, compare := "ASC", ">"
+	if q.Desc {
+		direction, compare = "DESC", "<"
+	}
+
+	query := q.Filter.apply(db.Where("account_id = ?", accountID))
+	if q.Cursor != "" {
+		value, id, err := decodeTransactionCursor(q.Sort, q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Where(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", q.Sort, compare), value, value, id)
+	} else if q.Page > 1 {
+		query = query.Offset((q.Page - 1) * q.Limit)
+	}
+
+	// Read one extra row to know whether there is a next page
+	var transactions []Transaction
+	order := fmt.Sprintf("%s %s, id %s", q.Sort, direction, direction)
+	if err := query.Order(order).Limit(q.Limit + 1).Find(&transactions).Error; err != nil {
+		return nil, "", err
+	}
+	if len(transactions) <= q.Limit {
+		return transactions, "", nil
+	}
+	transactions = transactions[:q.Limit]
+	cursor, err := encodeTransactionCursor(q.Sort, transactions[len(transactions)-1])
+	return transactions, cursor, err
+}
+
+func validSortField(field string) bool {
+	for _, f := range TransactionSortFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeTransactionCursor(field string, t Transaction) (string, error) {
+	var value interface{}
+	switch field {
+	case "date":
+		value = t.Date.Format(time.RFC3339)
+	case "symbol":
+		value = t.Symbol
+	case "action":
+		value = t.Action
+	case "quantity":
+		value = t.Quantity
+	case "price":
+		value = t.Price
+	case "amount":
+		value = t.Amount
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	cursor, err := json.Marshal(transactionCursor{Value: raw, ID: t.ID})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(cursor), nil
+}
+
+func decodeTransactionCursor(field, encoded string) (interface{}, uint, error) {
+	invalid := &InvalidTransactionQueryError{Reason: "invalid cursor"}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, 0, invalid
+	}
+	var cursor transactionCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, 0, invalid
+	}
+
+	switch field {
+	case "date":
+		var value string
+		if err := json.Unmarshal(cursor.Value, &value); err != nil {
+			return nil, 0, invalid
+		}
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, 0, invalid
+		}
+		return date, cursor.ID, nil
+	case "symbol", "action":
+		var value string
+		if err := json.Unmarshal(cursor.Value, &value); err != nil {
+			return nil, 0, invalid
+		}
+		return value, cursor.ID, nil
+	default:
+		var value float64
+		if err := json.Unmarshal(cursor.Value, &value); err != nil {
+			return nil, 0, invalid
+		}
+		return value, cursor.ID, nil
+	}
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -12,6 +13,13 @@ type User struct {
 	ID           uint   `gorm:"primary_key"`
 	Email        string `gorm:"size:100;unique;not null"`
 	PasswordHash string `gorm:"size:100"`
+	// EmailVerifiedAt is set once the user follows the link mailed on signup
+	EmailVerifiedAt *time.Time
+	// TOTPSecret is set on enrollment, two-factor login is only required once TOTPEnabledAt is set
+	TOTPSecret    string `gorm:"size:64" json:"-"`
+	TOTPEnabledAt *time.Time
+	// TOTPLastCounter is the time step of the last accepted code so a code can't be replayed
+	TOTPLastCounter uint64 `json:"-"`
 }
 
 const (
@@ -48,7 +56,7 @@ func (*UserIDDoesNotExistError) Error() string {
 
 func FindUserByEmail(db *gorm.DB, email string) (*User, error) {
 	var user User
-	res := db.Find(&user, &User{Email: email})
+	res := db.Where("email = ?", email).First(&user)
 	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 		return nil, &EmailNotExistsError{}
 	}
@@ -57,7 +65,7 @@ func FindUserByEmail(db *gorm.DB, email string) (*User, error) {
 
 func FindUserByID(db *gorm.DB, id uint) (*User, error) {
 	var user User
-	res := db.Find(&user, &User{ID: id})
+	res := db.First(&user, id)
 	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 		return nil, &UserIDDoesNotExistError{}
 	}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Purposes of the single use tokens mailed to users
+const (
+	TokenVerifyEmail   = "verify-email"
+	TokenResetPassword = "reset-password"
+)
+
+// How long mailed tokens can be used
+const (
+	VerifyEmailTokenTTL   = 48 * time.Hour
+	ResetPasswordTokenTTL = time.Hour
+)
+
+// ErrInvalidUserToken is returned for a mailed token that is unknown, expired or already used
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+// UserToken is a single use token mailed to a user. Only the hash of the token is stored.
+type UserToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Purpose   string `gorm:"size:20"`
+	TokenHash string `gorm:"size:64;uniqueIndex"`
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// CreateUserToken creates a token for the purpose, earlier unused tokens for the same purpose
+// stop working so only the latest mail is valid
+func CreateUserToken(db *gorm.DB, userID uint, purpose string, ttl time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&UserToken{}).Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeUserToken marks a token for the purpose as used and returns it
+func consumeUserToken(tx *gorm.DB, token, purpose string) (*UserToken, error) {
+	var userToken UserToken
+	err := tx.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&userToken).Error
+	if err != nil || userToken.UsedAt != nil || time.Now().After(userToken.ExpiresAt) {
+		return nil, ErrInvalidUserToken
+	}
+
+	// Only one request can use the token, a concurrent one finds it already used
+	result := tx.Model(&UserToken{}).Where("id = ? AND used_at IS NULL", userToken.ID).Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidUserToken
+	}
+	return &userToken, nil
+}
+
+// VerifyEmail uses a verification token to mark the user's email as verified
+func VerifyEmail(db *gorm.DB, token string) (*User, error) {
+	var user User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, token, TokenVerifyEmail)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, userToken.UserID).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		return tx.Model(&user).Update("email_verified_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ResetPassword uses a reset token to replace the user's password hash. Every session of the user
+// is revoked so someone holding the old password is logged out.
+func ResetPassword(db *gorm.DB, token, passwordHash string) (*User, error) {
+	var user User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		userToken, err := consumeUserToken(tx, token, TokenResetPassword)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, userToken.UserID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Update("password_hash", passwordHash).Error; err != nil {
+			return err
+		}
+		return RevokeUserSessions(tx, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}